@@ -0,0 +1,85 @@
+package wgo
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// ANSI escape codes used by the banner.
+const (
+	clearScreen = "\033[H\033[2J"
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorCyan   = "\033[36m"
+)
+
+// printBanner prints a one-line summary of the cycle to cmd.Stderr, e.g.
+// "[wgo] rebuilding: 3 files changed (a.go, b.go, c.go) — built in 1.2s — pid
+// 4412". Parts that are empty are skipped.
+func (cmd *RunCmd) printBanner(parts ...string) {
+	var b strings.Builder
+	b.WriteString(cmd.colorize(colorCyan, "[wgo]"))
+	separator := " "
+	for _, part := range parts {
+		if part == "" {
+			continue
+		}
+		b.WriteString(separator)
+		b.WriteString(part)
+		separator = " — "
+	}
+	b.WriteString("\n")
+	io.WriteString(cmd.Stderr, b.String())
+}
+
+// colorize wraps s in the ANSI color code, unless colors are disabled.
+func (cmd *RunCmd) colorize(color, s string) string {
+	if !cmd.useColor {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// describeChanges summarizes the changed files for the banner, e.g. "3 files
+// changed (a.go, b.go, c.go)". Only the first few file names are listed.
+func describeChanges(changed []fsnotify.Event) string {
+	var names []string
+	seen := make(map[string]struct{})
+	for _, event := range changed {
+		name := filepath.Base(event.Name)
+		if _, ok := seen[event.Name]; ok {
+			continue
+		}
+		seen[event.Name] = struct{}{}
+		names = append(names, name)
+	}
+	const max = 3
+	list := strings.Join(names, ", ")
+	if len(names) > max {
+		list = strings.Join(names[:max], ", ") + ", …"
+	}
+	if len(names) == 1 {
+		return fmt.Sprintf("1 file changed (%s)", list)
+	}
+	return fmt.Sprintf("%d files changed (%s)", len(names), list)
+}
+
+// isTerminal reports whether w is a terminal, as opposed to a file, a pipe or
+// an arbitrary io.Writer.
+func isTerminal(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fileinfo, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return fileinfo.Mode()&os.ModeCharDevice != 0
+}
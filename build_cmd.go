@@ -0,0 +1,139 @@
+package wgo
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+)
+
+// BuildCmd builds a Go package, rebuilding it whenever files change. Unlike
+// RunCmd it never runs the program, leaving it to be picked up by other
+// tools.
+type BuildCmd struct {
+	// (Required)
+	Package    string
+	Env        []string // Added on top of os.Environ() and EnvFiles.
+	EnvFiles   []string // Dotenv files, re-read on every rebuild.
+	Stdout     io.Writer
+	Stderr     io.Writer
+	BuildFlags []string
+	Output     string // Passed to go build -o (replaced only by successful builds). If empty, go build decides where the output goes.
+	// WatchOptions decide which files are watched and how every cycle is
+	// reported.
+	WatchOptions
+	// DiagnosticsFormat decides how build errors are printed. See
+	// RunCmd.DiagnosticsFormat.
+	DiagnosticsFormat string
+	// Hooks are commands (a name followed by its arguments) that run before
+	// every build, after every successful build and after every failed build.
+	// See RunCmd.BeforeBuild.
+	BeforeBuild    [][]string
+	AfterBuild     [][]string
+	OnBuildFailure [][]string
+	runCmdWrapper
+}
+
+func BuildCommand(args ...string) (*BuildCmd, error) {
+	cmd := BuildCmd{
+		BuildFlags: make([]string, 0, len(buildFlags)*2),
+	}
+	flagset := flag.NewFlagSet("", flag.ContinueOnError)
+	cmd.WatchOptions.addFlags(flagset)
+	flagset.StringVar(&cmd.Output, "o", "", "")
+	addHookFlag(flagset, "before-build", &cmd.BeforeBuild)
+	addHookFlag(flagset, "after-build", &cmd.AfterBuild)
+	addHookFlag(flagset, "on-build-failure", &cmd.OnBuildFailure)
+	addDiagnosticsFlag(flagset, &cmd.DiagnosticsFormat)
+	addEnvFlags(flagset, &cmd.Env, &cmd.EnvFiles)
+	addBuildFlags(flagset, &cmd.BuildFlags)
+	flagset.Usage = func() {
+		fmt.Fprint(flagset.Output(), `Build the package, rebuilding whenever *.go files (or the files matching the file patterns) change. The program is never run.
+Usage:
+  wgo build [BUILD_FLAGS...] [package]
+  wgo build -o ./bin/server ./cmd/server
+  wgo build -tags=fts5 -o ./bin/server ./cmd/server
+Flags:
+  Any flag that works with 'go build' works here.
+`+watchFlagsUsage+`
+  -env
+        A KEY=VALUE environment variable passed to go build. Can be repeated.
+  -envfile
+        A dotenv file containing KEY=VALUE lines passed to go build. Can be
+        repeated. The file is re-read whenever it changes.
+  -diagnostics
+        How build errors are printed: text (the raw output of go build) or
+        json (one JSON object per error, with the package, file, line, column
+        and message). Defaults to text.
+  -before-build, -after-build, -on-build-failure
+        A command to run before every build, after every successful build or
+        after every failed build, e.g. -before-build 'go generate ./...'. Can
+        be repeated, the commands run in order. If a -before-build command
+        fails the build is skipped and counts as failed.
+`)
+	}
+	err := flagset.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	flagArgs := flagset.Args()
+	switch len(flagArgs) {
+	case 0:
+		cmd.Package = "."
+	case 1:
+		cmd.Package = flagArgs[0]
+	default:
+		return nil, fmt.Errorf("only one package can be built, got %q", flagArgs)
+	}
+	return &cmd, nil
+}
+
+// Start starts watching files and kicks off the first build in the
+// background. Call Wait to wait for it to finish, or Stop to end it.
+func (cmd *BuildCmd) Start() error {
+	return cmd.StartContext(context.Background())
+}
+
+// StartContext is like Start but also stops everything (as if Stop() was
+// called) once the context is done.
+func (cmd *BuildCmd) StartContext(ctx context.Context) error {
+	// BuildCmd is a RunCmd that never runs the program it builds.
+	return cmd.start(ctx, &RunCmd{
+		Package:           cmd.Package,
+		Env:               cmd.Env,
+		EnvFiles:          cmd.EnvFiles,
+		Stdout:            cmd.Stdout,
+		Stderr:            cmd.Stderr,
+		BuildFlags:        cmd.BuildFlags,
+		Output:            cmd.Output,
+		WatchOptions:      cmd.WatchOptions,
+		DiagnosticsFormat: cmd.DiagnosticsFormat,
+		BeforeBuild:       cmd.BeforeBuild,
+		AfterBuild:        cmd.AfterBuild,
+		OnBuildFailure:    cmd.OnBuildFailure,
+		buildOnly:         true,
+	})
+}
+
+// Stop stops the watcher (and the build, if one is in progress). It does not
+// wait for everything to finish cleaning up, call Wait for that.
+func (cmd *BuildCmd) Stop() {
+	cmd.stop()
+}
+
+// Wait waits for the BuildCmd to stop and returns 1 if the last build failed,
+// 0 otherwise.
+func (cmd *BuildCmd) Wait() (exitCode int) {
+	return cmd.wait()
+}
+
+// Run starts the BuildCmd and blocks until Stop() is called. It returns 1 if
+// the last build failed, 0 otherwise.
+func (cmd *BuildCmd) Run() (exitCode int) {
+	return cmd.RunContext(context.Background())
+}
+
+// RunContext is like Run but also returns once the context is done.
+func (cmd *BuildCmd) RunContext(ctx context.Context) (exitCode int) {
+	return cmd.runContext(ctx, cmd.StartContext, cmd.Stderr)
+}
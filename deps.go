@@ -0,0 +1,112 @@
+package wgo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+)
+
+// listedPackage is the subset of `go list -json` output that we care about.
+type listedPackage struct {
+	ImportPath string
+	Name       string
+	Dir        string
+	Standard   bool
+	Deps       []string
+	GoFiles    []string
+	CgoFiles   []string
+	EmbedFiles []string
+	Module     *listedModule
+}
+
+type listedModule struct {
+	Path    string
+	Dir     string
+	GoMod   string
+	Main    bool
+	Replace *listedModule
+	Version string
+}
+
+// isLocal reports whether the package lives in the user's source tree, as
+// opposed to the standard library or the module cache.
+func (pkg listedPackage) isLocal() bool {
+	if pkg.Standard {
+		return false
+	}
+	if pkg.Module == nil || pkg.Module.Main {
+		return true
+	}
+	// Modules replaced by a local directory have no version.
+	return pkg.Module.Replace != nil && pkg.Module.Replace.Version == ""
+}
+
+// goList runs `go list -json` with the args and decodes its output.
+func goList(env []string, args ...string) ([]listedPackage, error) {
+	listCmd := exec.Command("go", append([]string{"list", "-json"}, args...)...)
+	listCmd.Env = env
+	stderr := &bytes.Buffer{}
+	listCmd.Stderr = stderr
+	output, err := listCmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list: %w\n%s", err, stderr.String())
+	}
+	var packages []listedPackage
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	for decoder.More() {
+		var pkg listedPackage
+		err = decoder.Decode(&pkg)
+		if err != nil {
+			return nil, fmt.Errorf("go list: %w", err)
+		}
+		packages = append(packages, pkg)
+	}
+	return packages, nil
+}
+
+// listDependencies returns the directories and files that the package (a
+// package path or a list of .go files) depends on according to `go list
+// -deps`: the Go, cgo and embedded files of every local package it imports
+// (including itself) and the go.mod and go.sum of the main module. Paths are
+// absolute.
+func listDependencies(env, buildFlags []string, pkg ...string) (dirs []string, files map[string]struct{}, err error) {
+	args := make([]string, 0, len(buildFlags)+len(pkg)+2)
+	args = append(args, "-deps", "-e")
+	args = append(args, buildFlags...)
+	args = append(args, pkg...)
+	packages, err := goList(env, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	files = make(map[string]struct{})
+	seenDirs := make(map[string]struct{})
+	addFile := func(name string) {
+		files[name] = struct{}{}
+		dir := filepath.Dir(name)
+		if _, ok := seenDirs[dir]; !ok {
+			seenDirs[dir] = struct{}{}
+			dirs = append(dirs, dir)
+		}
+	}
+	for _, pkg := range packages {
+		if !pkg.isLocal() || pkg.Dir == "" {
+			continue
+		}
+		if _, ok := seenDirs[pkg.Dir]; !ok {
+			seenDirs[pkg.Dir] = struct{}{}
+			dirs = append(dirs, pkg.Dir)
+		}
+		for _, names := range [][]string{pkg.GoFiles, pkg.CgoFiles, pkg.EmbedFiles} {
+			for _, name := range names {
+				addFile(filepath.Join(pkg.Dir, name))
+			}
+		}
+		if pkg.Module != nil && pkg.Module.Main && pkg.Module.GoMod != "" {
+			addFile(pkg.Module.GoMod)
+			addFile(filepath.Join(filepath.Dir(pkg.Module.GoMod), "go.sum"))
+		}
+	}
+	return dirs, files, nil
+}
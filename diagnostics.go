@@ -0,0 +1,62 @@
+package wgo
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Diagnostic is an error (or warning) reported by the compiler or go vet at a
+// position in a source file.
+type Diagnostic struct {
+	Package string `json:"package,omitempty"` // The package being built, from the "# pkg" header.
+	File    string `json:"file,omitempty"`    // As printed by go build, usually relative to the working directory.
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// diagnosticRegexp matches "file:line:col: message" and "file:line: message".
+// The file may start with a Windows drive letter.
+var diagnosticRegexp = regexp.MustCompile(`^((?:[A-Za-z]:)?[^:]+):(\d+)(?::(\d+))?: (.*)$`)
+
+// ParseDiagnostics parses the output of go build (or go vet) into
+// diagnostics. Indented lines following a diagnostic (such as the have/want
+// lines of a type error) are appended to its message. Lines that don't point
+// at a position in a file, like "go: cannot find main module", become
+// diagnostics with only a message so that nothing is lost.
+func ParseDiagnostics(output []byte) []Diagnostic {
+	var diagnostics []Diagnostic
+	var pkg string
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "# ") {
+			pkg = strings.TrimPrefix(line, "# ")
+			continue
+		}
+		if strings.HasPrefix(line, "\t") && len(diagnostics) > 0 {
+			diagnostics[len(diagnostics)-1].Message += "\n" + line
+			continue
+		}
+		// go vet prefixes type checking errors with "vet: ".
+		line = strings.TrimPrefix(line, "vet: ")
+		match := diagnosticRegexp.FindStringSubmatch(line)
+		if match == nil {
+			diagnostics = append(diagnostics, Diagnostic{Package: pkg, Message: line})
+			continue
+		}
+		diagnostic := Diagnostic{Package: pkg, File: match[1], Message: match[4]}
+		diagnostic.Line, _ = strconv.Atoi(match[2])
+		if match[3] != "" {
+			diagnostic.Column, _ = strconv.Atoi(match[3])
+		}
+		diagnostics = append(diagnostics, diagnostic)
+	}
+	return diagnostics
+}
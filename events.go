@@ -0,0 +1,93 @@
+package wgo
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// EventType is the type of an Event.
+type EventType int
+
+const (
+	// FileChanged is emitted once the file events that trigger a new cycle
+	// have settled down. Event.Files holds the file events.
+	FileChanged EventType = iota + 1
+	// BuildStarted is emitted right before the program is built.
+	BuildStarted
+	// BuildSucceeded is emitted after the program is built successfully.
+	// Event.Duration and Event.Output hold the duration and the output of
+	// the build.
+	BuildSucceeded
+	// BuildFailed is emitted after the build fails. Event.Duration and
+	// Event.Output hold the duration and the output of the build, Event.Err
+	// holds the error and Event.Diagnostics holds the errors parsed from the
+	// output.
+	BuildFailed
+	// ProgramStarted is emitted after the program is started. Event.Pid holds
+	// the program's process ID.
+	ProgramStarted
+	// ProgramExited is emitted after the program exits, whether on its own or
+	// because it was stopped. Event.Pid and Event.ExitCode hold the
+	// program's process ID and exit code (-1 if it was killed by a signal).
+	ProgramExited
+	// WatcherError is emitted when the file watcher reports an error.
+	// Event.Err holds the error.
+	WatcherError
+	// ProgramReady is emitted once the program passes its readiness probes
+	// (see RunCmd.ReadyAddr). Event.Pid and Event.Duration hold the
+	// program's process ID and how long it took to become ready.
+	ProgramReady
+	// ProgramNotReady is emitted if the program exits or times out before
+	// passing its readiness probes. Event.Err holds the reason.
+	ProgramNotReady
+)
+
+func (t EventType) String() string {
+	switch t {
+	case FileChanged:
+		return "FileChanged"
+	case BuildStarted:
+		return "BuildStarted"
+	case BuildSucceeded:
+		return "BuildSucceeded"
+	case BuildFailed:
+		return "BuildFailed"
+	case ProgramStarted:
+		return "ProgramStarted"
+	case ProgramExited:
+		return "ProgramExited"
+	case WatcherError:
+		return "WatcherError"
+	case ProgramReady:
+		return "ProgramReady"
+	case ProgramNotReady:
+		return "ProgramNotReady"
+	}
+	return "EventType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// Event describes something that happened during a clean + build + run cycle.
+// Which fields are set depends on the Type.
+type Event struct {
+	Type     EventType
+	Time     time.Time
+	Files    []fsnotify.Event
+	Duration time.Duration
+	Output   []byte
+	// Diagnostics are only set for BuildFailed.
+	Diagnostics []Diagnostic
+	Pid         int
+	ExitCode    int
+	Err         error
+}
+
+// emit calls cmd.OnEvent with the event, if OnEvent is set.
+func (cmd *RunCmd) emit(event Event) {
+	if cmd.OnEvent == nil {
+		return
+	}
+	event.Time = time.Now()
+	cmd.OnEvent(event)
+}
@@ -0,0 +1,205 @@
+package wgo
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Matcher matches file paths. Paths are relative to the watch root and use
+// forward slashes as the separator.
+type Matcher interface {
+	Match(path string) bool
+}
+
+// MatcherFunc is an adapter to allow the use of ordinary functions as
+// Matchers.
+type MatcherFunc func(path string) bool
+
+// Match calls f(path).
+func (f MatcherFunc) Match(path string) bool { return f(path) }
+
+// Rule includes (or excludes, if Exclude is true) the files matched by its
+// Matcher. Rules are evaluated in order and the last rule that matches a
+// file decides whether the file is included.
+type Rule struct {
+	Matcher Matcher
+	Exclude bool
+}
+
+// CompileGlob compiles a doublestar glob pattern into a Matcher. Patterns are
+// matched against the whole path: '*' matches any sequence of characters
+// except '/', '?' matches any single character except '/', '[...]' matches a
+// character class, '**' matches zero or more directories and '{a,b}' matches
+// either a or b. For example "**/*.tmpl", "assets/{css,js}/**".
+func CompileGlob(pattern string) (Matcher, error) {
+	alternatives, err := expandBraces(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("glob %q: %w", pattern, err)
+	}
+	globs := make([][]string, len(alternatives))
+	for i, alternative := range alternatives {
+		// Like gitignore, allow '!' to negate character classes.
+		alternative = strings.ReplaceAll(alternative, "[!", "[^")
+		globs[i] = strings.Split(alternative, "/")
+		for _, segment := range globs[i] {
+			if _, err := path.Match(segment, ""); err != nil {
+				return nil, fmt.Errorf("glob %q: %w", pattern, err)
+			}
+		}
+	}
+	return MatcherFunc(func(path string) bool {
+		segments := strings.Split(path, "/")
+		for _, glob := range globs {
+			if matchSegments(glob, segments) {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+// globRule returns the Rule for a glob pattern. A pattern starting with '!'
+// is negated, i.e. it excludes files if exclude is false and includes them if
+// exclude is true.
+func globRule(pattern string, exclude bool) (Rule, error) {
+	if strings.HasPrefix(pattern, "!") {
+		pattern, exclude = pattern[1:], !exclude
+	}
+	matcher, err := CompileGlob(pattern)
+	if err != nil {
+		return Rule{}, err
+	}
+	return Rule{Matcher: matcher, Exclude: exclude}, nil
+}
+
+// addGlobFlags registers the -glob and -xglob flags, which append their rules
+// to rules. They are documented by globFlagsUsage.
+func addGlobFlags(flagset *flag.FlagSet, rules *[]Rule) {
+	flagset.Func("glob", "", func(value string) error {
+		rule, err := globRule(value, false)
+		if err != nil {
+			return err
+		}
+		*rules = append(*rules, rule)
+		return nil
+	})
+	flagset.Func("xglob", "", func(value string) error {
+		rule, err := globRule(value, true)
+		if err != nil {
+			return err
+		}
+		*rules = append(*rules, rule)
+		return nil
+	})
+}
+
+// globFlagsUsage is the usage text of the flags added by addGlobFlags.
+const globFlagsUsage = `  -glob
+        A glob pattern (e.g. **/*.tmpl or assets/{css,js}/**) that matches the
+        paths of included files, relative to the root. A pattern starting
+        with ! excludes files instead (e.g. !**/*_test.go). Can be repeated;
+        -glob and -xglob patterns are evaluated in order, after the regexps,
+        and the last matching pattern wins.
+  -xglob
+        A glob pattern that matches the paths of excluded files. A pattern
+        starting with ! includes files instead.`
+
+// expandBraces expands every {a,b,...} alternation in the pattern, returning
+// all possible patterns. Braces may be nested.
+func expandBraces(pattern string) ([]string, error) {
+	start := -1
+	depth := 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++ // Skip the escaped character.
+		case '{':
+			if depth == 0 {
+				start = i
+			}
+			depth++
+		case '}':
+			if depth == 0 {
+				return nil, fmt.Errorf("unmatched '}'")
+			}
+			depth--
+			if depth > 0 {
+				continue
+			}
+			// Split the outermost braces into alternatives, then expand
+			// each alternative together with the rest of the pattern.
+			var patterns []string
+			for _, alternative := range splitAlternatives(pattern[start+1 : i]) {
+				expanded, err := expandBraces(pattern[:start] + alternative + pattern[i+1:])
+				if err != nil {
+					return nil, err
+				}
+				patterns = append(patterns, expanded...)
+			}
+			return patterns, nil
+		}
+	}
+	if depth > 0 {
+		return nil, fmt.Errorf("unmatched '{'")
+	}
+	return []string{pattern}, nil
+}
+
+// splitAlternatives splits the contents of a pair of braces by the commas
+// that are not inside nested braces.
+func splitAlternatives(s string) []string {
+	var alternatives []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				alternatives = append(alternatives, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(alternatives, s[start:])
+}
+
+// fileRules returns the rules that decide which files are watched. *.go files
+// are always included, followed by the files matching fileRegexps (against
+// the file name) or filepathRegexps (against the file path), minus the files
+// matching the exclude regexps. The extra rules are evaluated last, so they
+// can override everything before them.
+func fileRules(fileRegexps, filepathRegexps, excludeFileRegexps, excludeFilepathRegexps []*regexp.Regexp, extra []Rule) []Rule {
+	rules := make([]Rule, 0, 1+len(fileRegexps)+len(filepathRegexps)+len(excludeFileRegexps)+len(excludeFilepathRegexps)+len(extra))
+	rules = append(rules, Rule{Matcher: MatcherFunc(func(path string) bool {
+		return strings.HasSuffix(path, ".go")
+	})})
+	for _, r := range fileRegexps {
+		rules = append(rules, Rule{Matcher: basenameMatcher(r)})
+	}
+	for _, r := range filepathRegexps {
+		rules = append(rules, Rule{Matcher: MatcherFunc(r.MatchString)})
+	}
+	for _, r := range excludeFileRegexps {
+		rules = append(rules, Rule{Matcher: basenameMatcher(r), Exclude: true})
+	}
+	for _, r := range excludeFilepathRegexps {
+		rules = append(rules, Rule{Matcher: MatcherFunc(r.MatchString), Exclude: true})
+	}
+	return append(rules, extra...)
+}
+
+// basenameMatcher returns a Matcher that matches the regexp against the file
+// name only.
+func basenameMatcher(r *regexp.Regexp) Matcher {
+	return MatcherFunc(func(name string) bool {
+		return r.MatchString(path.Base(name))
+	})
+}
@@ -1,7 +1,7 @@
 module github.com/bokwoon95/watcher-go
 
-go 1.19
+go 1.20
 
 require github.com/fsnotify/fsnotify v1.6.0
 
-require golang.org/x/sys v0.0.0-20220908164124-27713097b956 // indirect
+require golang.org/x/sys v0.0.0-20220908164124-27713097b956
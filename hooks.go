@@ -0,0 +1,95 @@
+package wgo
+
+import (
+	"flag"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// runHooks runs the hooks one after the other (piping their stdout and stderr
+// to cmd.Stdout and cmd.Stderr), stopping at the first one that fails. name
+// identifies the kind of hook in the error message, e.g. "before-build".
+func (cmd *RunCmd) runHooks(name string, hooks [][]string, env []string) error {
+	for _, hook := range hooks {
+		if len(hook) == 0 {
+			continue
+		}
+		hookCmd := exec.CommandContext(cmd.ctx, hook[0], hook[1:]...)
+		hookCmd.Env = env
+		hookCmd.Stdout = cmd.Stdout
+		hookCmd.Stderr = cmd.Stderr
+		err := hookCmd.Run()
+		if err != nil {
+			if cmd.ctx.Err() == nil {
+				fmt.Fprintf(cmd.Stderr, "[wgo] %s hook %q failed: %v\n", name, strings.Join(hook, " "), err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// addHookFlag registers a repeatable flag whose values are commands that get
+// appended to hooks.
+func addHookFlag(flagset *flag.FlagSet, name string, hooks *[][]string) {
+	flagset.Func(name, "", func(value string) error {
+		hook, err := splitCommand(value)
+		if err != nil {
+			return err
+		}
+		if len(hook) == 0 {
+			return fmt.Errorf("empty command")
+		}
+		*hooks = append(*hooks, hook)
+		return nil
+	})
+}
+
+// splitCommand splits a command line into its arguments the way a shell
+// would, without expanding anything: arguments are separated by whitespace,
+// single and double quotes group characters into one argument, and a
+// backslash (outside single quotes) escapes the next character.
+func splitCommand(s string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, c := range s {
+		switch {
+		case escaped:
+			arg.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inArg = c, true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
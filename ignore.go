@@ -0,0 +1,238 @@
+package wgo
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignorer decides which files and directories are ignored according to the
+// .gitignore and .wgoignore files found in the directory tree. Ignore files
+// are read lazily the first time a path inside their directory is checked.
+//
+// https://git-scm.com/docs/gitignore#_pattern_format
+type ignorer struct {
+	// top is the highest directory whose ignore files are considered. It is
+	// the root of the git repository containing the watch root, or the watch
+	// root itself if it is not inside a git repository.
+	top string
+	// names are the ignore file names read in every directory, in order of
+	// increasing precedence.
+	names []string
+	// rules maps each directory (an absolute, slash-separated path) to the
+	// rules in its ignore files. A directory present with no rules has already
+	// been checked and doesn't contain any ignore files.
+	rules map[string][]ignoreRule
+}
+
+type ignoreRule struct {
+	segments []string // The pattern split by slash.
+	negate   bool     // The pattern started with '!'.
+	dirOnly  bool     // The pattern ended with '/'.
+}
+
+// newIgnorer returns an ignorer for the watch root. If useGitignore is false,
+// only .wgoignore files are read.
+func newIgnorer(root string, useGitignore bool) *ignorer {
+	ig := &ignorer{
+		names: []string{".wgoignore"},
+		rules: make(map[string][]ignoreRule),
+	}
+	if useGitignore {
+		ig.names = []string{".gitignore", ".wgoignore"}
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		absRoot = root
+	}
+	ig.top = filepath.ToSlash(absRoot)
+	// Ignore files above the watch root still apply if they belong to the
+	// same git repository.
+	for dir := absRoot; ; {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			ig.top = filepath.ToSlash(dir)
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return ig
+}
+
+// load (re)reads the ignore files in the directory.
+func (ig *ignorer) load(dir string) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return
+	}
+	var rules []ignoreRule
+	for _, name := range ig.names {
+		rules = append(rules, readIgnoreFile(filepath.Join(absDir, name))...)
+	}
+	ig.rules[filepath.ToSlash(absDir)] = rules
+}
+
+// isIgnoreFile reports whether the file is one of the ignore files read by
+// the ignorer.
+func (ig *ignorer) isIgnoreFile(name string) bool {
+	basename := filepath.Base(name)
+	for _, name := range ig.names {
+		if basename == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isIgnored reports whether the path is ignored, either by itself or because
+// one of its parent directories is ignored.
+func (ig *ignorer) isIgnored(name string, isDir bool) bool {
+	if ig == nil {
+		return false
+	}
+	absName, err := filepath.Abs(name)
+	if err != nil {
+		return false
+	}
+	absName = filepath.ToSlash(absName)
+	if absName != ig.top && !strings.HasPrefix(absName, strings.TrimSuffix(ig.top, "/")+"/") {
+		return false
+	}
+	// Check every parent directory below the top, starting from the
+	// outermost. Once a directory is ignored, everything inside it is
+	// ignored too (git doesn't allow re-including files inside an ignored
+	// directory).
+	rel := strings.TrimPrefix(strings.TrimPrefix(absName, ig.top), "/")
+	if rel == "" {
+		return false
+	}
+	segments := strings.Split(rel, "/")
+	for i := 1; i < len(segments); i++ {
+		if ig.match(path.Join(ig.top, strings.Join(segments[:i], "/")), true) {
+			return true
+		}
+	}
+	return ig.match(absName, isDir)
+}
+
+// match reports whether the path is ignored by the rules in the ignore files
+// of its parent directories. Rules in deeper directories take precedence, and
+// within the same directory later rules take precedence over earlier ones.
+func (ig *ignorer) match(name string, isDir bool) bool {
+	// Collect the parent directories from the innermost to the outermost.
+	var dirs []string
+	for dir := path.Dir(name); strings.HasPrefix(dir, ig.top); dir = path.Dir(dir) {
+		dirs = append(dirs, dir)
+		if dir == ig.top {
+			break
+		}
+	}
+	ignored := false
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dir := dirs[i]
+		rules, ok := ig.rules[dir]
+		if !ok {
+			ig.load(filepath.FromSlash(dir))
+			rules = ig.rules[dir]
+		}
+		rel := strings.Split(strings.TrimPrefix(strings.TrimPrefix(name, dir), "/"), "/")
+		for _, rule := range rules {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			if matchSegments(rule.segments, rel) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// readIgnoreFile parses the rules in a .gitignore-style file. A missing file
+// has no rules.
+func readIgnoreFile(name string) []ignoreRule {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		rule, ok := parseIgnoreRule(scanner.Text())
+		if ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func parseIgnoreRule(line string) (rule ignoreRule, ok bool) {
+	// Trailing spaces are ignored unless they are escaped with a backslash.
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false
+	}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return rule, false
+	}
+	// A pattern containing a slash (other than a trailing one) is anchored
+	// to the directory of the ignore file. Otherwise it matches at any
+	// depth.
+	if strings.Contains(line, "/") {
+		line = strings.TrimPrefix(line, "/")
+	} else {
+		line = "**/" + line
+	}
+	// gitignore negates character classes with '!', path.Match uses '^'.
+	line = strings.ReplaceAll(line, "[!", "[^")
+	rule.segments = strings.Split(line, "/")
+	return rule, true
+}
+
+// matchSegments matches a slash-separated name against a glob pattern split
+// into segments. Each segment is matched with path.Match, except for "**"
+// which matches zero or more segments. Like in gitignore, a trailing "**"
+// matches one or more segments, so "foo/**" matches everything inside foo but
+// not foo itself.
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" && len(pattern) == 1 {
+			return len(name) > 0
+		}
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		matched, err := path.Match(pattern[0], name[0])
+		if err != nil || !matched {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,47 @@
+package wgo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIgnorer(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"foo/bar.go", "foo/baz/qux.go", "build/main.go", "main.go", "main_test.go"} {
+		name = filepath.Join(root, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(name), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(name, nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := os.WriteFile(filepath.Join(root, ".wgoignore"), []byte("foo/**\nbuild/\n*_test.go\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ig := newIgnorer(root, false)
+	tests := []struct {
+		name    string
+		isDir   bool
+		ignored bool
+	}{
+		{name: "foo", isDir: true, ignored: false},
+		{name: "foo/bar.go", ignored: true},
+		{name: "foo/baz", isDir: true, ignored: true},
+		{name: "foo/baz/qux.go", ignored: true},
+		{name: "build", isDir: true, ignored: true},
+		{name: "build/main.go", ignored: true},
+		{name: "main.go", ignored: false},
+		{name: "main_test.go", ignored: true},
+	}
+	for _, tt := range tests {
+		ignored := ig.isIgnored(filepath.Join(root, filepath.FromSlash(tt.name)), tt.isDir)
+		if ignored != tt.ignored {
+			t.Errorf("isIgnored(%q) = %v, want %v", tt.name, ignored, tt.ignored)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package wgo
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// WatchOptions are the options shared by RunCmd, WatchCmd, BuildCmd and
+// TestCmd that decide which files are watched and how every clean + build +
+// run cycle is reported.
+type WatchOptions struct {
+	Root                   string           // The directory to start watching from. Defaults to ".".
+	DirRegexps             []*regexp.Regexp // TODO: Exclude and Include don't seem to work. Figure out how to get multiple specific directories to work. Figure out how to only watch files in the root folder nonrecursively. There may come a time where *.{go,html,tmpl,tpl} is no longer a sane default and users are often asking for css,js, or even more madness. Or even a general purpose task runner. But stay strong, because wgo run never seeks to achieve what go run couldn't already (it simply adds a file watcher to go run). (Are regexes rich enough to support omitting *_test.go? https://github.com/cosmtrek/air/issues/127)
+	FileRegexps            []*regexp.Regexp
+	FilepathRegexps        []*regexp.Regexp // TODO: normalize all filepath separators to forward slash.
+	ExcludeDirRegexps      []*regexp.Regexp
+	ExcludeFileRegexps     []*regexp.Regexp
+	ExcludeFilepathRegexps []*regexp.Regexp
+	// FileRules are glob (or custom) rules evaluated after the file regexps.
+	// The last rule that matches a file decides whether it is watched.
+	FileRules []Rule
+	// If Poll is true, files are checked for changes every PollInterval
+	// instead of relying on fsnotify. Polling works on filesystems where
+	// fsnotify doesn't, like Docker bind mounts and NFS. PollInterval
+	// defaults to 500ms.
+	Poll         bool
+	PollInterval time.Duration
+	// Directories and files matched by .gitignore and .wgoignore files are
+	// not watched. If NoGitignore is true, .gitignore files are not read
+	// (.wgoignore files still are).
+	NoGitignore bool
+	// OnEvent, if set, is called with every lifecycle event (file changes,
+	// builds, program starts and exits, watcher errors). It is called from
+	// the goroutine running the clean + build + run loop, so it should not
+	// block for long.
+	OnEvent func(Event)
+	// If ClearScreen is true, the terminal is cleared at the start of every
+	// clean + build + run cycle.
+	ClearScreen bool
+	// A one-line status banner is printed to Stderr on every cycle. It is
+	// colored if Stderr is a terminal, unless NoColor is true or the NO_COLOR
+	// environment variable is set.
+	NoColor bool
+}
+
+// addFlags registers the flags that set the WatchOptions. They are
+// documented by watchFlagsUsage.
+func (opts *WatchOptions) addFlags(flagset *flag.FlagSet) {
+	flagset.StringVar(&opts.Root, "root", "", "")
+	addRegexpFlag(flagset, "dir", &opts.DirRegexps)
+	addRegexpFlag(flagset, "files", &opts.FileRegexps)
+	addRegexpFlag(flagset, "filepaths", &opts.FilepathRegexps)
+	addRegexpFlag(flagset, "xdirs", &opts.ExcludeDirRegexps)
+	addRegexpFlag(flagset, "xfiles", &opts.ExcludeFileRegexps)
+	addRegexpFlag(flagset, "xfilepaths", &opts.ExcludeFilepathRegexps)
+	addGlobFlags(flagset, &opts.FileRules)
+	flagset.BoolVar(&opts.Poll, "poll", false, "")
+	flagset.DurationVar(&opts.PollInterval, "poll-interval", 0, "")
+	flagset.BoolVar(&opts.NoGitignore, "no-gitignore", false, "")
+	flagset.BoolVar(&opts.ClearScreen, "clear", false, "")
+	flagset.BoolVar(&opts.NoColor, "no-color", false, "")
+}
+
+// watchFlagsUsage is the usage text of the flags added by
+// WatchOptions.addFlags.
+const watchFlagsUsage = `  -dir, -files, -filepaths, -xdirs, -xfiles, -xfilepaths
+        Regexps that match the directories, file names and file paths to
+        watch (or exclude, for the x- variants). If -files or -filepaths is
+        provided, *.go files are watched in addition to the matching files.
+` + globFlagsUsage + `
+  -root
+        The directory to start watching files from. Defaults to the current
+        directory. The -dir and -filepaths regexps are matched against paths
+        relative to the root.
+  -poll
+        Poll files for changes instead of relying on filesystem
+        notifications. Use this if changes are not being picked up, e.g. in
+        Docker bind mounts, NFS or VirtualBox shared folders.
+  -poll-interval
+        How often to poll files for changes when -poll is set. Defaults to
+        500ms.
+  -no-gitignore
+        Don't skip files and directories matched by .gitignore files. Files
+        matched by .wgoignore files are always skipped.
+  -clear
+        Clear the terminal before every rebuild or rerun.
+  -no-color
+        Don't color the [wgo] status lines. Colors are already disabled if
+        the output is not a terminal or NO_COLOR is set.`
+
+// ProcessOptions are the options shared by RunCmd, WatchCmd and TestCmd that
+// decide how the program (or command) is run, stopped and restarted.
+type ProcessOptions struct {
+	// Restart decides what happens when the program exits on its own:
+	//
+	//	never       wait for the next file change (the default)
+	//	on-failure  restart the program if it exited with a non-zero code
+	//	always      restart the program
+	//	exit        make Run return with the program's exit code
+	//
+	// Restarts are delayed by RestartDelay (default 1 second), which doubles
+	// on every consecutive restart up to a minute. After MaxRestarts
+	// consecutive restarts (0 means no limit), wgo gives up and waits for the
+	// next file change. File changes reset the count, and so does a program
+	// that ran for a minute or more before exiting.
+	Restart      string
+	RestartDelay time.Duration
+	MaxRestarts  int
+	// ExitWithProgram is the same as Restart "exit".
+	ExitWithProgram bool
+	// Signal is sent to the program (and its child processes) to ask it to
+	// stop. Defaults to os.Interrupt. Windows has no signals, the program is
+	// sent a Ctrl-Break instead (which Go programs see as os.Interrupt).
+	Signal os.Signal
+	// StopTimeout is how long to wait for the program to exit after sending
+	// it Signal before killing it. Defaults to 5 seconds.
+	StopTimeout time.Duration
+	// If Interactive is true, Stdin is read line by line and the following
+	// lines are treated as commands instead of being passed to the program:
+	//
+	//	rs  rebuild and restart the program
+	//	r   restart the program without rebuilding it
+	//	c   clear the screen
+	//	q   quit
+	//
+	// Every other line is passed to the program. Lines starting with
+	// EscapePrefix are passed to the program with the prefix removed, so
+	// that the program can still receive lines like "rs". EscapePrefix
+	// defaults to a backslash.
+	Interactive  bool
+	EscapePrefix string
+	// When the program is stopped, its whole process group is stopped with
+	// it. On Linux, so are its descendants that left the process group (e.g.
+	// with setsid), as long as their parent is alive. If Cgroup is true, the
+	// program is also placed in a transient cgroup v2 so that processes that
+	// daemonize (by forking twice) are stopped too. Cgroup is Linux-only and
+	// needs write access to wgo's own cgroup.
+	Cgroup bool
+}
+
+// addFlags registers the flags that set the ProcessOptions. They are
+// documented by processFlagsUsage.
+func (opts *ProcessOptions) addFlags(flagset *flag.FlagSet) {
+	flagset.Func("restart", "", func(value string) error {
+		if !isRestartPolicy(value) {
+			return fmt.Errorf("expected never, on-failure, always or exit")
+		}
+		opts.Restart = value
+		return nil
+	})
+	flagset.DurationVar(&opts.RestartDelay, "restart-delay", 0, "")
+	flagset.IntVar(&opts.MaxRestarts, "max-restarts", 0, "")
+	flagset.BoolVar(&opts.ExitWithProgram, "exit", false, "")
+	flagset.Func("signal", "", func(value string) error {
+		signal, err := parseSignal(value)
+		if err != nil {
+			return err
+		}
+		opts.Signal = signal
+		return nil
+	})
+	flagset.DurationVar(&opts.StopTimeout, "stop-timeout", 0, "")
+	flagset.BoolVar(&opts.Interactive, "interactive", false, "")
+	flagset.StringVar(&opts.EscapePrefix, "escape", "", "")
+	flagset.BoolVar(&opts.Cgroup, "cgroup", false, "")
+}
+
+// processFlagsUsage returns the usage text of the flags added by
+// ProcessOptions.addFlags. noun is what is being run, e.g. "program" or
+// "command".
+func processFlagsUsage(noun string) string {
+	return strings.ReplaceAll(`  -restart
+        What to do when the PROGRAM exits on its own: never (wait for the
+        next file change), on-failure (restart it if it exited with a non-zero
+        code), always (restart it) or exit (exit wgo with the PROGRAM's exit
+        code). Defaults to never.
+  -restart-delay
+        How long to wait before restarting the PROGRAM. The delay doubles on
+        every consecutive restart, up to 1m. Defaults to 1s.
+  -max-restarts
+        Stop restarting the PROGRAM after this many consecutive restarts and
+        wait for the next file change instead. Defaults to 0 (no limit).
+  -exit
+        Same as -restart=exit.
+  -signal
+        The signal sent to the PROGRAM to stop it (INT, TERM, HUP, QUIT or
+        KILL). Defaults to INT. On Windows the PROGRAM is always sent a
+        Ctrl-Break.
+  -stop-timeout
+        How long to wait for the PROGRAM to exit after sending it the stop
+        signal before killing it. Defaults to 5s.
+  -interactive
+        Read commands from stdin: rs<Enter> rebuilds (if there is anything to
+        build) and restarts the PROGRAM, r<Enter> restarts it without
+        rebuilding, c<Enter> clears the screen and q<Enter> quits. Other lines
+        are passed to the PROGRAM.
+  -escape
+        Lines starting with this prefix are passed to the PROGRAM (minus the
+        prefix) even if they look like a command, when -interactive is set.
+        Defaults to a backslash, e.g. \rs<Enter> sends rs to the PROGRAM.
+  -cgroup
+        Linux only. Run the PROGRAM in a transient cgroup v2 so that every
+        process it spawns is killed when it is stopped, even the ones that
+        daemonize. Needs write access to wgo's own cgroup.`, "PROGRAM", noun)
+}
+
+// addRegexpFlag registers a repeatable flag whose values are compiled (see
+// compileRegexps) and appended to regexps.
+func addRegexpFlag(flagset *flag.FlagSet, name string, regexps *[]*regexp.Regexp) {
+	flagset.Func(name, "", func(value string) error {
+		compiled, err := compileRegexps([]string{value})
+		if err != nil {
+			return err
+		}
+		*regexps = append(*regexps, compiled...)
+		return nil
+	})
+}
+
+// addEnvFlags registers the repeatable -env and -envfile flags, which append
+// KEY=VALUE pairs to env and dotenv file names to envFiles.
+func addEnvFlags(flagset *flag.FlagSet, env, envFiles *[]string) {
+	flagset.Func("env", "", func(value string) error {
+		if !strings.Contains(value, "=") {
+			return fmt.Errorf("-env %q: expected KEY=VALUE", value)
+		}
+		*env = append(*env, value)
+		return nil
+	})
+	flagset.Func("envfile", "", func(value string) error {
+		*envFiles = append(*envFiles, value)
+		return nil
+	})
+}
+
+// addDiagnosticsFlag registers the -diagnostics flag, which sets format to
+// "text" or "json".
+func addDiagnosticsFlag(flagset *flag.FlagSet, format *string) {
+	flagset.Func("diagnostics", "", func(value string) error {
+		if value != "text" && value != "json" {
+			return fmt.Errorf("-diagnostics %q: expected text or json", value)
+		}
+		*format = value
+		return nil
+	})
+}
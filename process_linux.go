@@ -0,0 +1,230 @@
+//go:build linux
+
+package wgo
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// process identifies a process by its pid and its start time, so that an
+// unrelated process that reuses the pid after the original one exits isn't
+// mistaken for it.
+type process struct {
+	pid       int
+	startTime uint64 // In clock ticks since boot.
+}
+
+// running reports whether the process is still running, i.e. its pid still
+// belongs to a process that started at the same time.
+func (p process) running() bool {
+	_, startTime, err := readStat(p.pid)
+	return err == nil && startTime == p.startTime
+}
+
+// descendants returns every descendant of the process (children,
+// grandchildren and so on), found by walking the parent process IDs in
+// /proc. Unlike the process group, this includes descendants that called
+// setsid or setpgid, as long as their parent is still alive.
+func descendants(pid int) []process {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	children := make(map[int][]process)
+	for _, entry := range entries {
+		childPid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue // Not a process.
+		}
+		ppid, startTime, err := readStat(childPid)
+		if err != nil {
+			continue // The process exited in the meantime.
+		}
+		children[ppid] = append(children[ppid], process{pid: childPid, startTime: startTime})
+	}
+	var processes []process
+	queue := children[pid]
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		processes = append(processes, p)
+		queue = append(queue, children[p.pid]...)
+	}
+	return processes
+}
+
+// readStat returns the parent process ID and the start time of the process
+// from /proc/<pid>/stat.
+func readStat(pid int) (ppid int, startTime uint64, err error) {
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, 0, err
+	}
+	// The command name is in parentheses and may contain anything, the
+	// fields after it are "state ppid ..." and the start time is the 20th.
+	i := bytes.LastIndexByte(stat, ')')
+	if i < 0 {
+		return 0, 0, errors.New("malformed /proc/" + strconv.Itoa(pid) + "/stat")
+	}
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 20 {
+		return 0, 0, errors.New("malformed /proc/" + strconv.Itoa(pid) + "/stat")
+	}
+	ppid, err = strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	startTime, err = strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return ppid, startTime, nil
+}
+
+// cgroup is a transient cgroup v2 that programs are placed in, so that every
+// process they spawn can be killed, even the ones that daemonize by forking
+// twice and leave both the process group and the process tree.
+type cgroup struct {
+	dir  string
+	file *os.File // The cgroup directory, for clone(CLONE_INTO_CGROUP).
+}
+
+// newCgroup creates a cgroup for wgo's programs below wgo's own cgroup. It
+// needs cgroup v2 and write access to wgo's own cgroup (e.g. a delegated
+// systemd user slice, or root in a container).
+func newCgroup() (*cgroup, error) {
+	mountpoint, err := cgroup2Mountpoint()
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return nil, err
+	}
+	var path string
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			path = strings.TrimPrefix(line, "0::")
+			break
+		}
+	}
+	if path == "" {
+		return nil, errors.New("not running in a cgroup v2 hierarchy")
+	}
+	dir := filepath.Join(mountpoint, path, "wgo-"+strconv.Itoa(os.Getpid()))
+	err = os.Mkdir(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(dir)
+	if err != nil {
+		_ = os.Remove(dir)
+		return nil, err
+	}
+	return &cgroup{dir: dir, file: file}, nil
+}
+
+// cgroup2Mountpoint returns where the cgroup v2 hierarchy is mounted, usually
+// /sys/fs/cgroup (or /sys/fs/cgroup/unified on hybrid systems).
+func cgroup2Mountpoint() (string, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// 42 32 0:38 / /sys/fs/cgroup/unified rw,relatime - cgroup2 cgroup2 rw
+		before, after, ok := strings.Cut(scanner.Text(), " - ")
+		if !ok || !strings.HasPrefix(after, "cgroup2 ") {
+			continue
+		}
+		fields := strings.Fields(before)
+		if len(fields) >= 5 {
+			return fields[4], nil
+		}
+	}
+	return "", errors.New("cgroup v2 is not mounted")
+}
+
+// apply makes the program start inside the cgroup (Linux 5.7+), so that
+// every process it spawns is in the cgroup too. Moving the program into the
+// cgroup after it starts would miss the processes it spawns right away. It
+// is a no-op if the cgroup is nil.
+func (cg *cgroup) apply(program *exec.Cmd) {
+	if cg == nil {
+		return
+	}
+	if program.SysProcAttr == nil {
+		program.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	program.SysProcAttr.UseCgroupFD = true
+	program.SysProcAttr.CgroupFD = int(cg.file.Fd())
+}
+
+// unsupported reports whether err (from starting a program in the cgroup)
+// means that the kernel can't start processes in a cgroup: clone3 is missing
+// before Linux 5.3 (ENOSYS) and CLONE_INTO_CGROUP before Linux 5.7 (EINVAL).
+func (cg *cgroup) unsupported(err error) bool {
+	return cg != nil && (errors.Is(err, syscall.ENOSYS) || errors.Is(err, syscall.EINVAL))
+}
+
+// kill kills every process in the cgroup, waiting up to a second for them to
+// exit. It is a no-op if the cgroup is nil.
+func (cg *cgroup) kill() {
+	if cg == nil {
+		return
+	}
+	// cgroup.kill (Linux 5.14+) kills every process in the cgroup at once,
+	// including the ones being forked. On older kernels the processes are
+	// killed one by one until there are none left.
+	err := os.WriteFile(filepath.Join(cg.dir, "cgroup.kill"), []byte("1"), 0644)
+	for i := 0; i < 50; i++ {
+		pids := cg.pids()
+		if len(pids) == 0 {
+			return
+		}
+		if err != nil {
+			for _, pid := range pids {
+				_ = syscall.Kill(pid, syscall.SIGKILL)
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// pids returns the process IDs in the cgroup.
+func (cg *cgroup) pids() []int {
+	b, err := os.ReadFile(filepath.Join(cg.dir, "cgroup.procs"))
+	if err != nil {
+		return nil
+	}
+	var pids []int
+	for _, field := range strings.Fields(string(b)) {
+		pid, err := strconv.Atoi(field)
+		if err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// remove kills every process in the cgroup and removes it. It is a no-op if
+// the cgroup is nil.
+func (cg *cgroup) remove() {
+	if cg == nil {
+		return
+	}
+	cg.kill()
+	_ = cg.file.Close()
+	_ = os.Remove(cg.dir)
+}
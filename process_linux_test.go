@@ -0,0 +1,189 @@
+//go:build linux
+
+package wgo
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// TestHelperListener is not a real test, it is run as a child process by
+// TestStopProgram to hold a port open until it is killed.
+func TestHelperListener(t *testing.T) {
+	addr := os.Getenv("WGO_TEST_LISTEN")
+	if addr == "" {
+		t.Skip("helper process")
+	}
+	// Only SIGKILL gets rid of it.
+	signal.Ignore(os.Interrupt)
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		os.Exit(1)
+	}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			os.Exit(1)
+		}
+		conn.Close()
+	}
+}
+
+// TestStopProgram checks that stopping the program also kills a descendant
+// that left the program's process group with setsid.
+func TestStopProgram(t *testing.T) {
+	if _, err := exec.LookPath("setsid"); err != nil {
+		t.Skip("setsid not found")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	// Not an io.Discard pipe, if the listener survived it would keep the pipe
+	// open and Wait would never return.
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	cmd := &WatchCmd{
+		Name:   "sh",
+		Args:   []string{"-c", `setsid "$0" -test.run='^TestHelperListener$' & sleep 1000`, os.Args[0]},
+		Env:    []string{"WGO_TEST_LISTEN=" + addr},
+		Stdout: devNull,
+		Stderr: devNull,
+	}
+	cmd.Root = t.TempDir()
+	cmd.StopTimeout = 100 * time.Millisecond
+	err = cmd.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Stop()
+	listening := false
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			listening = true
+			break
+		}
+	}
+	if !listening {
+		t.Fatalf("the listener never started listening on %s", addr)
+	}
+	cmd.Stop()
+	cmd.Wait()
+	// SIGKILL is asynchronous, give the kernel a moment to release the port.
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		ln, err := net.Listen("tcp", addr)
+		if err == nil {
+			ln.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s is still in use after the program was stopped: %v", addr, err)
+		}
+	}
+}
+
+// TestHelperTerminal is not a real test, it is run by TestProgramReadsTerminal
+// with a terminal as its stdin and controlling terminal, like wgo run in a
+// shell.
+func TestHelperTerminal(t *testing.T) {
+	if os.Getenv("WGO_TEST_TERMINAL") == "" {
+		t.Skip("helper process")
+	}
+	cmd := &WatchCmd{
+		Name: "sh",
+		Args: []string{"-c", `read line && echo "got $line"`},
+	}
+	cmd.Root = t.TempDir()
+	cmd.Restart = "exit"
+	exitCode := cmd.Run()
+	if exitCode != 0 {
+		t.Fatalf("the program exited with %d", exitCode)
+	}
+}
+
+// TestProgramReadsTerminal checks that the program can read from wgo's
+// terminal instead of being stopped by SIGTTIN.
+func TestProgramReadsTerminal(t *testing.T) {
+	ptmx, pts, err := openPty()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ptmx.Close()
+	defer pts.Close()
+	// Not a pipe, a stopped program would keep it open and Wait would never
+	// return.
+	output, err := os.CreateTemp(t.TempDir(), "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+	helper := exec.Command(os.Args[0], "-test.run=^TestHelperTerminal$")
+	helper.Env = append(os.Environ(), "WGO_TEST_TERMINAL=1")
+	helper.Stdin = pts
+	helper.Stdout = output
+	helper.Stderr = output
+	helper.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	err = helper.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ptmx.Write([]byte("hello\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- helper.Wait() }()
+	select {
+	case err = <-done:
+	case <-time.After(10 * time.Second):
+		_ = helper.Process.Kill()
+		<-done
+		err = errors.New("timed out waiting for the program to read from the terminal")
+	}
+	b, _ := os.ReadFile(output.Name())
+	if err != nil || !strings.Contains(string(b), "got hello") {
+		t.Fatalf("%v\n%s", err, b)
+	}
+}
+
+// openPty opens a new pseudo-terminal, returning its master and slave ends.
+func openPty() (ptmx, pts *os.File, err error) {
+	ptmx, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var unlock int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, ptmx.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock)))
+	if errno != 0 {
+		ptmx.Close()
+		return nil, nil, errno
+	}
+	var n uint32
+	_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, ptmx.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n)))
+	if errno != 0 {
+		ptmx.Close()
+		return nil, nil, errno
+	}
+	pts, err = os.OpenFile("/dev/pts/"+strconv.Itoa(int(n)), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		ptmx.Close()
+		return nil, nil, err
+	}
+	return ptmx, pts, nil
+}
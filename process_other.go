@@ -0,0 +1,35 @@
+//go:build !linux
+
+package wgo
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// process identifies a process. Only Linux can list a program's descendants,
+// so there are none elsewhere.
+type process struct {
+	pid int
+}
+
+func (p process) running() bool { return false }
+
+// descendants is only implemented on Linux, elsewhere wgo relies on process
+// groups (or TASKKILL /T on Windows) to find the program's child processes.
+func descendants(pid int) []process { return nil }
+
+// cgroup is only supported on Linux.
+type cgroup struct{}
+
+func newCgroup() (*cgroup, error) {
+	return nil, errors.New("cgroups are only supported on Linux")
+}
+
+func (cg *cgroup) apply(program *exec.Cmd) {}
+
+func (cg *cgroup) unsupported(err error) bool { return false }
+
+func (cg *cgroup) kill() {}
+
+func (cg *cgroup) remove() {}
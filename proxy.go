@@ -0,0 +1,202 @@
+package wgo
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// reloadPath is the path of the server-sent events endpoint that the injected
+// script listens on.
+const reloadPath = "/__wgo/reload"
+
+// reloadScript is injected into every HTML response passing through the
+// proxy. It reloads the page whenever the proxy sends a reload event.
+const reloadScript = `<script>new EventSource("` + reloadPath + `").addEventListener("reload", function() { location.reload(); });</script>`
+
+// liveReloadProxy is a reverse proxy in front of the program that reloads the
+// browser after every restart. While the program is being rebuilt or
+// restarted, requests are held until it is ready instead of failing.
+type liveReloadProxy struct {
+	target  *url.URL
+	server  *http.Server
+	proxy   *httputil.ReverseProxy
+	mu      sync.Mutex
+	ready   chan struct{} // closed once the program is ready to take requests
+	clients map[chan struct{}]struct{}
+}
+
+// newLiveReloadProxy starts a proxy listening on addr that forwards requests
+// to target.
+func newLiveReloadProxy(addr, target string) (*liveReloadProxy, error) {
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("-target %q: %w", target, err)
+	}
+	if targetURL.Scheme == "" || targetURL.Host == "" {
+		return nil, fmt.Errorf("-target %q: expected a URL like http://localhost:8080", target)
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	p := &liveReloadProxy{
+		target:  targetURL,
+		ready:   make(chan struct{}),
+		clients: make(map[chan struct{}]struct{}),
+	}
+	p.proxy = httputil.NewSingleHostReverseProxy(targetURL)
+	director := p.proxy.Director
+	p.proxy.Director = func(r *http.Request) {
+		director(r)
+		// Ask for an uncompressed response so that the script can be
+		// injected into it.
+		r.Header.Del("Accept-Encoding")
+	}
+	p.proxy.ModifyResponse = injectReloadScript
+	p.proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		http.Error(w, "[wgo] "+err.Error(), http.StatusBadGateway)
+	}
+	p.server = &http.Server{Handler: p}
+	go p.server.Serve(listener)
+	return p, nil
+}
+
+func (p *liveReloadProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == reloadPath {
+		p.serveEvents(w, r)
+		return
+	}
+	// Hold the request until the program is ready.
+	p.mu.Lock()
+	ready := p.ready
+	p.mu.Unlock()
+	select {
+	case <-ready:
+	case <-r.Context().Done():
+		return
+	}
+	p.proxy.ServeHTTP(w, r)
+}
+
+// serveEvents sends a reload event to the browser every time the program is
+// restarted.
+func (p *liveReloadProxy) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	client := make(chan struct{}, 1)
+	p.mu.Lock()
+	p.clients[client] = struct{}{}
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.clients, client)
+		p.mu.Unlock()
+	}()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-client:
+			io.WriteString(w, "event: reload\ndata: reload\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// pause holds incoming requests until the next call to resume. It is a no-op
+// if the proxy is nil.
+func (p *liveReloadProxy) pause() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.ready:
+		p.ready = make(chan struct{})
+	default: // Already paused.
+	}
+}
+
+// resume lets held requests through. If reload is true, connected browsers
+// are also told to reload. It is a no-op if the proxy is nil.
+func (p *liveReloadProxy) resume(reload bool) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.ready:
+	default:
+		close(p.ready)
+	}
+	if !reload {
+		return
+	}
+	for client := range p.clients {
+		select {
+		case client <- struct{}{}:
+		default: // A reload is already pending.
+		}
+	}
+}
+
+// targetAddress returns the host:port of the target, for dialing it.
+func (p *liveReloadProxy) targetAddress() string {
+	if p.target.Port() != "" {
+		return p.target.Host
+	}
+	if p.target.Scheme == "https" {
+		return net.JoinHostPort(p.target.Hostname(), "443")
+	}
+	return net.JoinHostPort(p.target.Hostname(), "80")
+}
+
+// close shuts down the proxy. It is a no-op if the proxy is nil.
+func (p *liveReloadProxy) close() {
+	if p == nil {
+		return
+	}
+	p.server.Close()
+}
+
+// injectReloadScript injects the reload script into HTML responses, right
+// before the closing </body> tag (or at the end if there is none).
+func injectReloadScript(resp *http.Response) error {
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return nil
+	}
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	i := bytes.LastIndex(bytes.ToLower(body), []byte("</body>"))
+	if i < 0 {
+		i = len(body)
+	}
+	body = append(body[:i:i], append([]byte(reloadScript), body[i:]...)...)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
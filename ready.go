@@ -0,0 +1,109 @@
+package wgo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// waitReady probes the program in the background until it is ready, sending
+// nil to the returned channel once every probe passes: readyAddr accepts TCP
+// connections, cmd.ReadyURL returns a 2xx status and readyLine (if non-nil)
+// is closed. It sends an error instead if the program exits or is still not
+// ready after cmd.ReadyTimeout.
+func (cmd *RunCmd) waitReady(readyAddr string, readyLine <-chan struct{}, programDone <-chan struct{}) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		timer := time.NewTimer(cmd.ReadyTimeout)
+		defer timer.Stop()
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		client := &http.Client{Timeout: time.Second}
+		for {
+			ready := true
+			if readyAddr != "" {
+				conn, err := net.DialTimeout("tcp", readyAddr, time.Second)
+				if err != nil {
+					ready = false
+				} else {
+					conn.Close()
+				}
+			}
+			if ready && cmd.ReadyURL != "" {
+				resp, err := client.Get(cmd.ReadyURL)
+				if err != nil {
+					ready = false
+				} else {
+					resp.Body.Close()
+					ready = resp.StatusCode >= 200 && resp.StatusCode < 300
+				}
+			}
+			if ready && readyLine != nil {
+				select {
+				case <-readyLine:
+				default:
+					ready = false
+				}
+			}
+			if ready {
+				result <- nil
+				return
+			}
+			select {
+			case <-cmd.ctx.Done():
+				return
+			case <-programDone:
+				result <- errors.New("program exited before it was ready")
+				return
+			case <-timer.C:
+				result <- fmt.Errorf("program not ready after %s", cmd.ReadyTimeout)
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return result
+}
+
+// regexpWriter is an io.Writer that closes matched as soon as a line written
+// to it matches the regexp. It is shared by the program's stdout and stderr.
+type regexpWriter struct {
+	regexp  *regexp.Regexp
+	mu      sync.Mutex
+	buf     []byte
+	matched chan struct{}
+}
+
+func newRegexpWriter(r *regexp.Regexp) *regexpWriter {
+	return &regexpWriter{regexp: r, matched: make(chan struct{})}
+}
+
+func (w *regexpWriter) Write(p []byte) (n int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	select {
+	case <-w.matched:
+		return len(p), nil // Already matched, nothing left to do.
+	default:
+	}
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := w.buf[:i]
+		w.buf = w.buf[i+1:]
+		if w.regexp.Match(bytes.TrimRight(line, "\r")) {
+			close(w.matched)
+			w.buf = nil
+			break
+		}
+	}
+	return len(p), nil
+}
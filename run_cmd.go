@@ -1,7 +1,11 @@
 package wgo
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -11,8 +15,11 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 	"unicode/utf8"
 
@@ -29,132 +36,271 @@ var buildFlags = [...]string{
 
 type RunCmd struct {
 	// (Required)
-	Package                string
-	Env                    []string  // TODO: write a test to see if env variables passed to wgo are propagated to the program. https://github.com/cosmtrek/air/issues/58 full_bin SUCKS! using the live env vars is better! make sure it works. https://github.com/cosmtrek/air/pull/166 Is it possible to set env vars in one line on windows and pass them in to wgo? https://github.com/cosmtrek/air/issues/338 https://superuser.com/q/1405650 Maybe don't bother with the one line requirement, that is needed for air because it runs a single line command from its config file whereas wgo is able to access the shell in its entirety. If people want to source a dev.env file before running wgo they can (but make sure that works) https://github.com/cosmtrek/air/issues/77
-	Dir                    string    // TODO: reintroduce Dir string so that people can cd to a different directory, watch different files outside the project root and have peace of mind that they are able to run the binary in a completely different directory. https://github.com/cosmtrek/air/issues/85
-	Stdin                  io.Reader // TODO: need to test if it is possible to type rs<Enter> on macOS and if so implement nodemon's custom restart command. https://github.com/cosmtrek/air/issues/351
-	Stdout                 io.Writer
-	Stderr                 io.Writer
-	BuildFlags             []string
-	Output                 string           // TODO: when I include the -o flag it hangs. Why?
-	Args                   []string         // TODO: oh hell no, go run uses -- as the args separator. Need to rearchitect the application already.
-	DirRegexps             []*regexp.Regexp // TODO: Exclude and Include don't seem to work. Figure out how to get multiple specific directories to work. Figure out how to only watch files in the root folder nonrecursively. There may come a time where *.{go,html,tmpl,tpl} is no longer a sane default and users are often asking for css,js, or even more madness. Or even a general purpose task runner. But stay strong, because wgo run never seeks to achieve what go run couldn't already (it simply adds a file watcher to go run). (Are regexes rich enough to support omitting *_test.go? https://github.com/cosmtrek/air/issues/127)
-	FileRegexps            []*regexp.Regexp
-	FilepathRegexps        []*regexp.Regexp // TODO: normalize all filepath separators to forward slash.
-	ExcludeDirRegexps      []*regexp.Regexp
-	ExcludeFileRegexps     []*regexp.Regexp
-	ExcludeFilepathRegexps []*regexp.Regexp
-	watcher                *fsnotify.Watcher
-	started                int32
-	programPath            string
+	Package    string    // The package to build, or a .go file.
+	Files      []string  // More .go files of the same package, if Package is a .go file (like go run main.go helpers.go).
+	Env        []string  // Added on top of os.Environ() and EnvFiles, for both the build and the program.
+	EnvFiles   []string  // Dotenv files, re-read on every restart.
+	Dir        string    // The working directory of the program. Defaults to wgo's working directory.
+	Stdin      io.Reader // Passed to the program, see Interactive.
+	Stdout     io.Writer
+	Stderr     io.Writer
+	BuildFlags []string
+	Output     string   // Where to write the program. It is replaced only by successful builds and never deleted. Defaults to a temp file.
+	Args       []string // The arguments passed to the program.
+	// WatchOptions decide which files are watched and how every cycle is
+	// reported.
+	WatchOptions
+	// Files matching RestartFileRegexps or RestartFilepathRegexps (as well as
+	// EnvFiles) only restart the program without rebuilding it.
+	RestartFileRegexps     []*regexp.Regexp
+	RestartFilepathRegexps []*regexp.Regexp
+	// ProcessOptions decide how the program is run, stopped and restarted.
+	ProcessOptions
+	// If Deps is true, only the files that the package depends on (as
+	// reported by go list -deps) are watched instead of every directory under
+	// Root. This includes files embedded with //go:embed. The dependencies
+	// are recomputed on every rebuild, so new imports are picked up.
+	Deps bool
+	// DiagnosticsFormat decides how build errors are printed to Stderr. If it
+	// is "json", each error is printed as a JSON-encoded Diagnostic (one per
+	// line) instead of the raw output of go build. Defaults to "text".
+	DiagnosticsFormat string
+	// If ProxyAddr is set, a reverse proxy listening on ProxyAddr forwards
+	// requests to ProxyTarget (e.g. http://localhost:8080), the URL that the
+	// program serves on. The proxy injects a script into HTML responses that
+	// reloads the page whenever the program is restarted, and holds requests
+	// while the program is being rebuilt instead of failing them.
+	ProxyAddr   string
+	ProxyTarget string
+	proxy       *liveReloadProxy
+	// If any of ReadyAddr, ReadyURL or ReadyRegexp is set, the program is
+	// only considered ready once ReadyAddr accepts TCP connections, ReadyURL
+	// returns a 2xx status and ReadyRegexp matches a line of the program's
+	// output (stdout or stderr). If the program isn't ready within
+	// ReadyTimeout (default 30 seconds), the restart is reported as failed.
+	// The proxy only reloads the browser once the program is ready; if no
+	// probe is set, it waits for ProxyTarget to accept TCP connections.
+	ReadyAddr    string
+	ReadyURL     string
+	ReadyRegexp  *regexp.Regexp
+	ReadyTimeout time.Duration
+	// Hooks are commands (a name followed by its arguments) that run at
+	// points of every cycle, one after the other, in wgo's working directory
+	// and with the same environment as the program:
+	//
+	//	BeforeBuild    before building the program
+	//	AfterBuild     after the program is built successfully
+	//	OnBuildFailure after the build (or a BeforeBuild hook) fails
+	//	BeforeRun      before starting the program
+	//	AfterRun       after the program is started, or once it is ready if
+	//	               there are readiness probes
+	//
+	// A failing BeforeBuild hook aborts the cycle like a failed build, and a
+	// failing BeforeRun hook keeps the program from starting.
+	BeforeBuild    [][]string
+	AfterBuild     [][]string
+	OnBuildFailure [][]string
+	BeforeRun      [][]string
+	AfterRun       [][]string
+	// cgroup is the program's transient cgroup, if Cgroup is true.
+	cgroup *cgroup
+	// readyLine is closed once ReadyRegexp matches a line of the running
+	// program's output.
+	readyLine <-chan struct{}
+	useColor  bool
+	// programStdin is the stdin of the running program if Interactive is
+	// true.
+	programStdin io.WriteCloser
+	watcher      Watcher
+	started      int32
+	programPath  string
+	// If command is non-nil, there is nothing to build and the command it
+	// returns is run in place of the program on every cycle. It is passed the
+	// files that changed since the last cycle (nil for the first cycle). If it
+	// returns an empty command, nothing is run. It is used by WatchCmd and
+	// TestCmd.
+	command func(env, changed []string) []string
+	// If buildOnly is true, the program is built but never run. It is used
+	// by BuildCmd.
+	buildOnly bool
+	mu        sync.Mutex
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      chan struct{} // closed when the clean + build + run loop exits
+	exitCode  int
 }
 
 func RunCommand(args ...string) (*RunCmd, error) {
 	cmd := RunCmd{
 		BuildFlags: make([]string, 0, len(buildFlags)*2),
 	}
-	var dirs, files, filepaths, xdirs, xfiles, xfilepaths []string
 	flagset := flag.NewFlagSet("", flag.ContinueOnError)
+	cmd.WatchOptions.addFlags(flagset)
+	cmd.ProcessOptions.addFlags(flagset)
 	flagset.StringVar(&cmd.Output, "o", "", "")
-	flagset.Func("dir", "", func(value string) error {
-		dirs = append(dirs, value)
-		return nil
-	})
-	flagset.Func("files", "", func(value string) error {
-		files = append(files, value)
-		return nil
-	})
-	flagset.Func("filepaths", "", func(value string) error {
-		filepaths = append(filepaths, value)
-		return nil
-	})
-	flagset.Func("xdirs", "", func(value string) error {
-		xdirs = append(xdirs, value)
-		return nil
-	})
-	flagset.Func("xfiles", "", func(value string) error {
-		xfiles = append(xfiles, value)
-		return nil
-	})
-	flagset.Func("xfilepaths", "", func(value string) error {
-		xfilepaths = append(xfilepaths, value)
+	flagset.StringVar(&cmd.Dir, "workdir", "", "")
+	addEnvFlags(flagset, &cmd.Env, &cmd.EnvFiles)
+	addRegexpFlag(flagset, "restart-files", &cmd.RestartFileRegexps)
+	addRegexpFlag(flagset, "restart-filepaths", &cmd.RestartFilepathRegexps)
+	flagset.BoolVar(&cmd.Deps, "deps", false, "")
+	flagset.StringVar(&cmd.ProxyAddr, "proxy", "", "")
+	flagset.StringVar(&cmd.ProxyTarget, "target", "", "")
+	flagset.StringVar(&cmd.ReadyAddr, "ready-addr", "", "")
+	flagset.StringVar(&cmd.ReadyURL, "ready-url", "", "")
+	flagset.Func("ready-regexp", "", func(value string) error {
+		r, err := regexp.Compile(value)
+		if err != nil {
+			return err
+		}
+		cmd.ReadyRegexp = r
 		return nil
 	})
-	for i := range buildFlags {
-		name := buildFlags[i]
-		flagset.Func(name, "The -"+name+" flag in go build.", func(value string) error {
-			switch name {
-			case "a", "n", "race", "msan", "asan", "v", "work", "x",
-				"buildvcs", "linkshared", "modcacherw", "trimpath": // bool flags
-				if value == "" {
-					value = "true"
-				}
-			}
-			cmd.BuildFlags = append(cmd.BuildFlags, "-"+name, value)
-			return nil
-		})
-	}
+	flagset.DurationVar(&cmd.ReadyTimeout, "ready-timeout", 0, "")
+	addHookFlag(flagset, "before-build", &cmd.BeforeBuild)
+	addHookFlag(flagset, "after-build", &cmd.AfterBuild)
+	addHookFlag(flagset, "on-build-failure", &cmd.OnBuildFailure)
+	addHookFlag(flagset, "before-run", &cmd.BeforeRun)
+	addHookFlag(flagset, "after-run", &cmd.AfterRun)
+	addDiagnosticsFlag(flagset, &cmd.DiagnosticsFormat)
+	addBuildFlags(flagset, &cmd.BuildFlags)
 	flagset.Usage = func() {
-		fmt.Fprint(flagset.Output(), `Build and run the package, rebuilding and rerunning whenever *.{go,html,tmpl,tpl} files change.
+		fmt.Fprint(flagset.Output(), `Build and run the package, rebuilding and rerunning whenever *.go files (or the files matching the file patterns) change.
 Usage:
-  wgo run [BUILD_FLAGS...] <package_or_file> [ARGS...]
+  wgo run [FLAGS...] [BUILD_FLAGS...] <package | files...> [-- ARGS...]
   wgo run main.go
+  wgo run main.go helpers.go
   wgo run .
   wgo run -tags=fts5 ./cmd/main
-  wgo run -tags=fts5 ./cmd/main arg1 arg2 arg3
+  wgo run -tags=fts5 ./cmd/main -- -port 8080 arg1 arg2
+If a build fails, the previous program keeps running until the next successful
+build. The flags must come before the package. Everything after -- is passed to the
+program as is, even if it looks like a flag. Without --, the arguments after
+the package (or after the last .go file) are passed to the program too.
 Flags:
   Any flag that works with 'go build' works here.
-  -exclude
-        A regexp that matches excluded files. This works in conjuction with the
-        *.{go,html,tmpl,tpl} pattern.
-  -include
-        A regexp that matches included files. If provided, this overrides the
-        *.{go,html,tmpl,tpl} pattern. You will have to include go files
-        yourself using the regex.
+`+watchFlagsUsage+`
+`+processFlagsUsage("program")+`
+  -workdir
+        The directory to run the program in. Defaults to the current
+        directory.
+  -env
+        A KEY=VALUE environment variable passed to the program. Can be
+        repeated. The program also inherits wgo's own environment.
+  -envfile
+        A dotenv file containing KEY=VALUE lines passed to the program. Can be
+        repeated. The file is re-read (and the program restarted) whenever
+        it changes.
+  -restart-files
+        A regexp that matches files which only restart the program when they
+        change, without rebuilding it (e.g. config files).
+  -restart-filepaths
+        Like -restart-files, but matches the file path instead of the file
+        name.
+  -deps
+        Only watch the files that the package depends on (as reported by
+        'go list -deps'), including //go:embed files, instead of every
+        directory under the root.
+  -diagnostics
+        How build errors are printed: text (the raw output of go build) or
+        json (one JSON object per error, with the package, file, line, column
+        and message). Defaults to text.
+  -proxy
+        The address (e.g. :3000) of a live reload proxy to run in front of
+        the program. Pages opened through the proxy reload automatically
+        whenever the program is restarted. Requires -target.
+  -target
+        The URL that the program serves on (e.g. http://localhost:8080), for
+        -proxy.
+  -ready-addr
+        A TCP address (e.g. localhost:8080) that accepts connections once the
+        program is ready. wgo reports how long the program took to become
+        ready, and the proxy only reloads the browser once it is.
+  -ready-url
+        An HTTP URL that returns a 2xx status once the program is ready.
+  -ready-regexp
+        A regexp that matches a line of the program's output once the
+        program is ready, e.g. 'listening on'.
+  -ready-timeout
+        How long to wait for the program to become ready before reporting the
+        restart as failed. Defaults to 30s.
+  -before-build, -after-build, -on-build-failure, -before-run, -after-run
+        A command to run before building the program, after building it,
+        after the build fails, before starting the program or after starting
+        it (once it is ready, if there are -ready-* probes). e.g.
+        -before-build 'go generate ./...'. Can be repeated, the commands run
+        in order. If a -before-build command fails the cycle is aborted like
+        a failed build, if a -before-run command fails the program is not
+        started.
 `)
 	}
+	// The flag package prints the whole usage text on every error, which
+	// would bury the unknown flag message below. Only print it for -h.
+	flagset.SetOutput(io.Discard)
 	err := flagset.Parse(args)
 	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			flagset.SetOutput(os.Stderr)
+			flagset.Usage()
+			return nil, err
+		}
+		if strings.HasPrefix(err.Error(), "flag provided but not defined: ") {
+			name := strings.TrimPrefix(err.Error(), "flag provided but not defined: ")
+			return nil, fmt.Errorf("unknown flag %s (if it is meant for the program, pass it after --, e.g. wgo run . -- %s)", name, name)
+		}
 		return nil, err
 	}
-	cmd.DirRegexps, err = compileRegexps(dirs)
-	if err != nil {
-		return nil, err
-	}
-	cmd.FileRegexps, err = compileRegexps(files)
-	if err != nil {
-		return nil, err
-	}
-	cmd.FilepathRegexps, err = compileRegexps(filepaths)
-	if err != nil {
-		return nil, err
-	}
-	cmd.ExcludeDirRegexps, err = compileRegexps(xdirs)
-	if err != nil {
-		return nil, err
-	}
-	cmd.ExcludeFileRegexps, err = compileRegexps(xfiles)
-	if err != nil {
-		return nil, err
-	}
-	cmd.ExcludeFilepathRegexps, err = compileRegexps(xfilepaths)
-	if err != nil {
-		return nil, err
-	}
+	// flagset.Parse stops at the package, the remaining args are the package
+	// (or files), followed by the program's args.
 	flagArgs := flagset.Args()
+	var programArgs []string
+	hasSeparator := false
+	for i, arg := range flagArgs {
+		if arg == "--" {
+			flagArgs, programArgs, hasSeparator = flagArgs[:i], flagArgs[i+1:], true
+			break
+		}
+	}
 	if len(flagArgs) == 0 {
 		return nil, fmt.Errorf("package or file not provided")
 	}
-	cmd.Package, cmd.Args = flagArgs[0], flagArgs[1:]
+	cmd.Package, flagArgs = flagArgs[0], flagArgs[1:]
+	if strings.HasSuffix(cmd.Package, ".go") {
+		// Like go run, every .go file that follows belongs to the package.
+		for len(flagArgs) > 0 && strings.HasSuffix(flagArgs[0], ".go") {
+			cmd.Files = append(cmd.Files, flagArgs[0])
+			flagArgs = flagArgs[1:]
+		}
+	}
+	if hasSeparator && len(flagArgs) > 0 {
+		if strings.HasPrefix(flagArgs[0], "-") {
+			return nil, fmt.Errorf("flag %s after the package: flags must come before the package, program args after --", flagArgs[0])
+		}
+		return nil, fmt.Errorf("unexpected argument %q after the package: program args must come after --", flagArgs[0])
+	}
+	// Copy the args, flagArgs shares its backing array with the caller's args.
+	cmd.Args = append(append([]string(nil), flagArgs...), programArgs...)
 	return &cmd, nil
 }
 
-func (cmd *RunCmd) Start() {
+// Start starts watching files and kicks off the first clean + build + run
+// cycle in the background. Call Wait to wait for it to finish, or Stop to end
+// it.
+func (cmd *RunCmd) Start() error {
+	return cmd.StartContext(context.Background())
+}
+
+// StartContext is like Start but also stops everything (as if Stop() was
+// called) once the context is done.
+func (cmd *RunCmd) StartContext(ctx context.Context) error {
 	if !atomic.CompareAndSwapInt32(&cmd.started, 0, 1) {
 		// Start() should only run once, subsequent calls to Start() are
 		// ignored.
-		return
+		return fmt.Errorf("wgo: already started")
 	}
+	cmd.mu.Lock()
+	cmd.ctx, cmd.cancel = context.WithCancel(ctx)
+	cmd.mu.Unlock()
+	cmd.done = make(chan struct{})
 	if cmd.Stdin == nil {
 		cmd.Stdin = os.Stdin
 	}
@@ -164,30 +310,154 @@ func (cmd *RunCmd) Start() {
 	if cmd.Stderr == nil {
 		cmd.Stderr = os.Stderr
 	}
+	if cmd.Signal == nil {
+		cmd.Signal = os.Interrupt
+	}
+	if cmd.StopTimeout == 0 {
+		cmd.StopTimeout = 5 * time.Second
+	}
+	if cmd.Root == "" {
+		cmd.Root = "."
+	}
+	if cmd.ReadyTimeout == 0 {
+		cmd.ReadyTimeout = 30 * time.Second
+	}
+	if cmd.EscapePrefix == "" {
+		cmd.EscapePrefix = `\`
+	}
+	if cmd.Restart == "" {
+		cmd.Restart = "never"
+		if cmd.ExitWithProgram {
+			cmd.Restart = "exit"
+		}
+	}
+	if !isRestartPolicy(cmd.Restart) {
+		cmd.cancel()
+		close(cmd.done)
+		return fmt.Errorf("wgo: invalid Restart %q: expected never, on-failure, always or exit", cmd.Restart)
+	}
+	if cmd.RestartDelay == 0 {
+		cmd.RestartDelay = time.Second
+	}
+	cmd.useColor = !cmd.NoColor && os.Getenv("NO_COLOR") == "" && isTerminal(cmd.Stderr)
 	// Create a temp path for the program by default, unless the user specified
 	// a custom output.
-	cmd.programPath = filepath.Join(os.TempDir(), "main"+time.Now().Format("20060102150405"))
-	if cmd.Output != "" {
-		cmd.programPath = cmd.Output
+	var err error
+	if cmd.command == nil && !cmd.buildOnly {
+		cmd.programPath = filepath.Join(os.TempDir(), "main"+time.Now().Format("20060102150405"))
+	}
+	if cmd.Output != "" && cmd.command == nil {
+		// Make the output path absolute, otherwise it would be resolved
+		// relative to cmd.Dir when running the program.
+		cmd.programPath, err = filepath.Abs(cmd.Output)
+		if err != nil {
+			cmd.cancel()
+			close(cmd.done)
+			return err
+		}
 	}
 	// Windows refuses to run programs without an .exe extension, add it for
 	// the user if they didn't include it.
-	if runtime.GOOS == "windows" && !strings.HasSuffix(cmd.programPath, ".exe") {
+	if runtime.GOOS == "windows" && cmd.programPath != "" && !strings.HasSuffix(cmd.programPath, ".exe") {
 		cmd.programPath += ".exe"
 	}
+	if cmd.Poll {
+		cmd.watcher = NewPollingWatcher(cmd.PollInterval)
+	} else {
+		cmd.watcher, err = NewWatcher()
+	}
+	if err != nil {
+		cmd.cancel()
+		close(cmd.done)
+		return err
+	}
+	if cmd.Cgroup {
+		cmd.cgroup, err = newCgroup()
+		if err != nil {
+			fmt.Fprintf(cmd.Stderr, "[wgo] can't create a cgroup, falling back to process groups: %v\n", err)
+			err = nil
+		}
+	}
+	if cmd.ProxyAddr != "" {
+		if cmd.ProxyTarget == "" {
+			err = fmt.Errorf("wgo: -proxy requires -target")
+		} else {
+			cmd.proxy, err = newLiveReloadProxy(cmd.ProxyAddr, cmd.ProxyTarget)
+		}
+		if err != nil {
+			_ = cmd.watcher.Close()
+			cmd.cgroup.remove()
+			cmd.cancel()
+			close(cmd.done)
+			return err
+		}
+		fmt.Fprintf(cmd.Stderr, "[wgo] proxying %s to %s\n", cmd.ProxyAddr, cmd.ProxyTarget)
+	}
+	go cmd.loop()
+	return nil
+}
+
+// loop runs clean + build + run cycles until the context is done or (if
+// Restart is "exit") the program exits.
+func (cmd *RunCmd) loop() {
+	watcher := cmd.watcher
+	var program *exec.Cmd
+	var programDone chan struct{} // closed when the program exits
+	defer func() {
+		if program != nil {
+			cmd.stopProgram(program, programDone)
+			cmd.setExitCode(program)
+		}
+		_ = watcher.Close()
+		cmd.proxy.close()
+		cmd.cgroup.remove()
+		// Only remove the program if it's our own temp file, the user's
+		// output belongs to the user.
+		if cmd.programPath != "" && cmd.Output == "" {
+			_ = os.Remove(cmd.programPath)
+		}
+		cmd.cancel()
+		close(cmd.done)
+	}()
 	// 'watched' tracks which dirs are currently present in the watcher.
 	watched := make(map[string]struct{})
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		fmt.Fprintln(cmd.Stderr, err)
-		return
+	// 'ig' decides which files and directories are ignored by .gitignore and
+	// .wgoignore files.
+	ig := newIgnorer(cmd.Root, !cmd.NoGitignore)
+	// 'rules' decide which files trigger a rebuild.
+	rules := fileRules(cmd.FileRegexps, cmd.FilepathRegexps, cmd.ExcludeFileRegexps, cmd.ExcludeFilepathRegexps, cmd.FileRules)
+	// 'deps' holds the files that the package depends on if cmd.Deps is
+	// true. The directories containing them are added to the watcher on every
+	// build instead of walking the root.
+	var deps map[string]struct{}
+	if !cmd.Deps {
+		addDirsRecursively(watcher, watched, ig, cmd.DirRegexps, cmd.ExcludeDirRegexps, cmd.Root, cmd.Root)
+	}
+	// buildPath is where go build writes the program. The program is built
+	// to a temp file next to programPath first, then renamed into place only
+	// if the build succeeds (and the old program has been stopped). That way
+	// the old program keeps running if the build fails, and the user's
+	// output always holds the last good build and never a half-written one.
+	buildPath := cmd.programPath
+	if cmd.programPath != "" {
+		buildPath = filepath.Join(filepath.Dir(cmd.programPath), "."+filepath.Base(cmd.programPath)+".wgo"+strconv.Itoa(os.Getpid()))
+		// If the loop exits between a successful build and the rename (e.g.
+		// the context is cancelled while the build finishes), don't leave the
+		// temp file next to the output. Once renamed, there is nothing to
+		// remove.
+		defer func() {
+			_ = os.Remove(buildPath)
+		}()
+	}
+	// go build -o <buildPath> [BUILD_FLAGS...] <package | files...>
+	buildArgs := make([]string, 0, len(cmd.BuildFlags)+len(cmd.Files)+4)
+	buildArgs = append(buildArgs, "build")
+	if buildPath != "" {
+		buildArgs = append(buildArgs, "-o", buildPath)
 	}
-	addDirsRecursively(watcher, watched, cmd.DirRegexps, cmd.ExcludeDirRegexps, ".")
-	// go build -o <programPath> [BUILD_FLAGS...] <package>
-	buildArgs := make([]string, 0, len(cmd.BuildFlags)+4)
-	buildArgs = append(buildArgs, "build", "-o", cmd.programPath)
 	buildArgs = append(buildArgs, cmd.BuildFlags...)
 	buildArgs = append(buildArgs, cmd.Package)
+	buildArgs = append(buildArgs, cmd.Files...)
 	// The timer is used to debounce events. When a valid event arrives, it
 	// starts the timer. Only when the timer expires does it actually kick off
 	// a clean + build + run cycle. This means events that come in too quickly
@@ -203,70 +473,285 @@ func (cmd *RunCmd) Start() {
 	// Drain the initial timer event so that it doesn't count to the first
 	// iteration of the for-select loop.
 	<-timer.C
-	var program *exec.Cmd
+
+	// needBuild is true if the next cycle has to rebuild the program. It is
+	// false if only restart-only files have changed since the last successful
+	// build, or if there is no program to build at all.
+	needBuild := cmd.command == nil
+	// changed holds the file events since the last cycle.
+	var changed []fsnotify.Event
+	// readyAddr is the TCP address probed for readiness. The proxy target is
+	// probed by default, so that the browser isn't reloaded too early.
+	readyAddr := cmd.ReadyAddr
+	if readyAddr == "" && cmd.ReadyURL == "" && cmd.ReadyRegexp == nil && cmd.proxy != nil {
+		readyAddr = cmd.proxy.targetAddress()
+	}
+	// ready receives the result of the readiness probes of the running
+	// program, if there are any probes.
+	var ready <-chan error
+	var programPid int
+	var programStartTime time.Time
+	// restarts counts the consecutive restarts of a program that exited on
+	// its own. restartTimer fires when the next restart is due.
+	var restarts int
+	var restartTimer <-chan time.Time
+	// lines receives the lines read from stdin if cmd.Interactive is true.
+	var lines <-chan string
+	if cmd.Interactive {
+		lines = cmd.readLines()
+	}
 
 	// Clean + Build + Run cycle.
-	for {
-		// Clean up the program (if exists) and any child processes.
-		if program != nil && program.Process != nil {
-			cleanup(program)
-		}
-		// Set the program to nil; it will be non-nil if buildCmd succeeds
-		// without error.
-		// program = nil // if we're checking program.Process we don't need to set program to nil anymore to indicate the command has stopped.
-		// Build the program (piping its stdout and stderr to cmd.Stdout and
-		// cmd.Stderr).
-		buildCmd := exec.Command("go", buildArgs...)
-		buildCmd.Env = cmd.Env
-		buildCmd.Stdout = cmd.Stdout
-		buildCmd.Stderr = cmd.Stderr
-		err = buildCmd.Run()
-		// if errors.Is(err, context.Cancelled) || errors.Is(err, context.DeadlineExceeded) { return }
-		if err == nil {
-			// Run the program in the background (piping its stdout and stderr to
-			// cmd.Stdout and cmd.Stderr).
-			program = exec.Command(cmd.programPath, cmd.Args...)
-			program.Env = []string{"bruh='hehe'"}
-			program.Stdin = cmd.Stdin
-			program.Stdout = cmd.Stdout
-			program.Stderr = cmd.Stderr
-			setpgid(program)
-			go program.Run()
+	for first := true; ; first = false {
+		if cmd.ClearScreen {
+			io.WriteString(cmd.Stdout, clearScreen)
 		}
+		// status, buildStatus and programStatus make up the banner printed
+		// at the end of the cycle.
+		var status, buildStatus, programStatus string
+		switch {
+		case first:
+			status = "starting"
+		case needBuild:
+			status = "rebuilding"
+		case cmd.command != nil:
+			status = "rerunning"
+		default:
+			status = "restarting"
+		}
+		if len(changed) > 0 {
+			status += ": " + describeChanges(changed)
+		}
+		// Reload the environment, so that changes to the env files are picked
+		// up on every restart.
+		env, err := cmd.environ()
+		// built is true if a new program was built in this cycle.
+		built := false
+		if err != nil {
+			fmt.Fprintln(cmd.Stderr, err)
+		} else if needBuild {
+			if cmd.Deps {
+				// Recompute the dependencies in case an import or go.mod
+				// changed.
+				deps = cmd.watchDependencies(watcher, watched, deps, env)
+			}
+			err = cmd.runHooks("before-build", cmd.BeforeBuild, env)
+			if err != nil {
+				buildStatus = cmd.colorize(colorRed, "before-build hook failed")
+			} else {
+				cmd.emit(Event{Type: BuildStarted})
+				startTime := time.Now()
+				var output []byte
+				output, err = cmd.build(buildArgs, env)
+				duration := time.Since(startTime)
+				if cmd.ctx.Err() == nil {
+					if err != nil {
+						diagnostics := ParseDiagnostics(output)
+						if cmd.DiagnosticsFormat == "json" {
+							encoder := json.NewEncoder(cmd.Stderr)
+							for _, diagnostic := range diagnostics {
+								_ = encoder.Encode(diagnostic)
+							}
+						}
+						cmd.emit(Event{Type: BuildFailed, Duration: duration, Output: output, Err: err, Diagnostics: diagnostics})
+					} else {
+						cmd.emit(Event{Type: BuildSucceeded, Duration: duration, Output: output})
+					}
+					if err != nil {
+						buildStatus = cmd.colorize(colorRed, "build failed in "+duration.Round(time.Millisecond).String())
+					} else {
+						buildStatus = cmd.colorize(colorGreen, "built in "+duration.Round(time.Millisecond).String())
+					}
+				}
+			}
+			if err == nil {
+				built = true
+			} else {
+				if buildPath != cmd.programPath {
+					_ = os.Remove(buildPath)
+				}
+				if cmd.ctx.Err() == nil {
+					_ = cmd.runHooks("on-build-failure", cmd.OnBuildFailure, env)
+				}
+			}
+		}
+		if cmd.ctx.Err() != nil {
+			return // The build was killed because the context is done.
+		}
+		programRunning := false
+		if program != nil {
+			select {
+			case <-programDone:
+			default:
+				programRunning = true
+			}
+		}
+		if err != nil && programRunning {
+			// The old program is still good, leave it running instead of
+			// leaving nothing running until the error is fixed.
+			cmd.exitCode = 1
+			programStatus = "pid " + strconv.Itoa(programPid) + " still running"
+			cmd.printBanner(status, buildStatus, programStatus)
+		} else {
+			// Hold the proxy's requests until the program is back up.
+			cmd.proxy.pause()
+			// Clean up the program (if exists) and any child processes.
+			if program != nil {
+				cmd.stopProgram(program, programDone)
+				cmd.setExitCode(program)
+				program, programDone = nil, nil
+			}
+			if built && buildPath != cmd.programPath {
+				// Now that the old program is stopped (Windows can't replace
+				// a running executable), move the new one into place. Rename
+				// is atomic, so anything that runs the output sees either the
+				// old program or the new one.
+				err = os.Rename(buildPath, cmd.programPath)
+				if err != nil {
+					fmt.Fprintln(cmd.Stderr, err)
+					_ = os.Remove(buildPath)
+				}
+			}
+			if built && err == nil {
+				_ = cmd.runHooks("after-build", cmd.AfterBuild, env)
+			}
+			if err != nil {
+				// Mirror go run, which exits with 1 if the build fails.
+				cmd.exitCode = 1
+			} else if cmd.buildOnly {
+				cmd.exitCode = 0
+			} else {
+				// The build succeeded, later restarts can reuse the program
+				// until the next build-worthy file change.
+				needBuild = false
+				name, args := cmd.programPath, cmd.Args
+				if cmd.command != nil {
+					name, args = "", nil
+					var names []string
+					for _, event := range changed {
+						names = append(names, event.Name)
+					}
+					if command := cmd.command(env, names); len(command) > 0 {
+						name, args = command[0], command[1:]
+					}
+				}
+				if name != "" {
+					err = cmd.runHooks("before-run", cmd.BeforeRun, env)
+					if err == nil {
+						program, programDone, err = cmd.startProgram(env, name, args)
+						if err != nil {
+							fmt.Fprintln(cmd.Stderr, err)
+						}
+					}
+					if err != nil {
+						cmd.exitCode = 1
+					} else {
+						programPid, programStartTime = program.Process.Pid, time.Now()
+						programStatus = "pid " + strconv.Itoa(programPid)
+						cmd.emit(Event{Type: ProgramStarted, Pid: programPid})
+					}
+				}
+			}
+			cmd.printBanner(status, buildStatus, programStatus)
+			ready = nil
+			if program != nil && (readyAddr != "" || cmd.ReadyURL != "" || cmd.readyLine != nil) {
+				ready = cmd.waitReady(readyAddr, cmd.readyLine, programDone)
+			} else {
+				// There is nothing to wait for, let the requests through.
+				cmd.proxy.resume(false)
+				if program != nil {
+					_ = cmd.runHooks("after-run", cmd.AfterRun, env)
+				}
+			}
+		}
+		changed = nil
 		// Wait for file events. When a valid event comes in 'rebuild' will be
 		// set to true, breaking the wait loop and initiating another clean +
 		// build + run cycle.
 		rebuild := false
 		for rebuild == false {
 			select {
-			case err = <-watcher.Errors:
-				fmt.Fprintln(cmd.Stderr, err)
-			case event, ok := <-watcher.Events:
-				if !ok {
-					// TODO: cleanup(program) if program is running, otherwise
-					// we are forcibly ending main() and killing the goroutine
-					// (potentially not letting the server do its cleanup).
-					// TODO: instead of returning here, craft our own <-done:
-					// channel to make it really clear how this loop is
-					// exiting. Then we don't have to check for the zero value
-					// from the events channel or whatever.
-					return // cmd.Stop() was called which called watcher.Close().
+			case <-cmd.ctx.Done(): // cmd.Stop() was called or the context was cancelled.
+				return
+			case <-programDone: // The program exited on its own.
+				cmd.setExitCode(program)
+				state := program.ProcessState
+				program, programDone = nil, nil
+				if cmd.Restart == "exit" {
+					return
 				}
+				what := "program"
+				if cmd.command != nil {
+					what = "command"
+				}
+				exited := "exited with code " + strconv.Itoa(state.ExitCode())
+				if state.ExitCode() < 0 {
+					exited = "exited (" + state.String() + ")"
+				}
+				if cmd.Restart == "never" || (cmd.Restart == "on-failure" && state.Success()) {
+					fmt.Fprintf(cmd.Stderr, "[wgo] %s %s, waiting for changes\n", what, exited)
+					continue
+				}
+				if time.Since(programStartTime) >= restartResetAfter {
+					restarts = 0
+				}
+				if cmd.MaxRestarts > 0 && restarts >= cmd.MaxRestarts {
+					fmt.Fprintf(cmd.Stderr, "[wgo] %s %s, gave up after %d restarts, waiting for changes\n", what, exited, restarts)
+					continue
+				}
+				delay := restartDelay(cmd.RestartDelay, restarts)
+				restarts++
+				fmt.Fprintf(cmd.Stderr, "[wgo] %s %s, restarting in %s\n", what, exited, delay)
+				restartTimer = time.After(delay)
+			case <-restartTimer:
+				restartTimer = nil
+				rebuild = true
+			case err = <-ready:
+				ready = nil
+				duration := time.Since(programStartTime)
+				if err != nil {
+					fmt.Fprintf(cmd.Stderr, "[wgo] %s\n", err)
+					cmd.exitCode = 1
+					cmd.emit(Event{Type: ProgramNotReady, Pid: programPid, Duration: duration, Err: err})
+					cmd.proxy.resume(false)
+				} else {
+					fmt.Fprintf(cmd.Stderr, "[wgo] ready in %s\n", duration.Round(time.Millisecond))
+					cmd.emit(Event{Type: ProgramReady, Pid: programPid, Duration: duration})
+					cmd.proxy.resume(true)
+					_ = cmd.runHooks("after-run", cmd.AfterRun, env)
+				}
+			case err = <-watcher.Errors():
+				fmt.Fprintln(cmd.Stderr, err)
+				cmd.emit(Event{Type: WatcherError, Err: err})
+			case event := <-watcher.Events():
 				// We're only interested in Create | Write | Remove events,
 				// ignore everything else.
 				if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) && !event.Has(fsnotify.Remove) {
 					continue
 				}
 				if !isDir(event.Name) {
-					if isValid(cmd.FileRegexps, cmd.FilepathRegexps, cmd.ExcludeFileRegexps, cmd.ExcludeFilepathRegexps, event.Name) {
+					if ig.isIgnoreFile(event.Name) {
+						// Pick up the new rules for subsequent events.
+						ig.load(filepath.Dir(event.Name))
+						continue
+					}
+					if cmd.isRestartFile(event.Name) {
+						// There is no program to restart if we're only
+						// building, rebuild instead.
+						needBuild = needBuild || cmd.buildOnly
+						changed = append(changed, event)
+						timer.Reset(500 * time.Millisecond) // Start the timer.
+					} else if isDependency(deps, event.Name) || (!ig.isIgnored(event.Name, false) && isValid(rules, relPath(cmd.Root, event.Name))) {
+						needBuild = cmd.command == nil
+						changed = append(changed, event)
 						timer.Reset(500 * time.Millisecond) // Start the timer.
 					}
 					continue
 				}
 				// If a directory was created, recursively add every directory
 				// inside it to the watcher.
-				if event.Has(fsnotify.Create) {
-					addDirsRecursively(watcher, watched, cmd.DirRegexps, cmd.ExcludeDirRegexps, event.Name)
+				if event.Has(fsnotify.Create) && !cmd.Deps {
+					addDirsRecursively(watcher, watched, ig, cmd.DirRegexps, cmd.ExcludeDirRegexps, cmd.Root, event.Name)
 					continue
 				}
 				// If a directory was removed, recursively remove every
@@ -276,28 +761,426 @@ func (cmd *RunCmd) Start() {
 					continue
 				}
 			case <-timer.C: // Timer expired, start the rebuild.
+				cmd.emit(Event{Type: FileChanged, Files: changed})
+				restarts, restartTimer = 0, nil
 				rebuild = true
 				break
+			case line, ok := <-lines:
+				if !ok {
+					lines = nil // Stdin is closed, stop reading from it.
+					continue
+				}
+				switch line {
+				case "rs", "r":
+					if line == "rs" {
+						needBuild = cmd.command == nil
+					}
+					// Restart right away, and don't let a pending timer
+					// trigger another restart afterwards.
+					if !timer.Stop() {
+						select {
+						case <-timer.C:
+						default:
+						}
+					}
+					restarts, restartTimer = 0, nil
+					rebuild = true
+				case "c":
+					io.WriteString(cmd.Stdout, clearScreen)
+				case "q":
+					return
+				default:
+					if program != nil && cmd.programStdin != nil {
+						line = strings.TrimPrefix(line, cmd.EscapePrefix)
+						_, _ = io.WriteString(cmd.programStdin, line+"\n")
+					}
+				}
+			}
+		}
+	}
+}
+
+// build builds the program (piping its stdout and stderr to cmd.Stdout and
+// cmd.Stderr). It also returns the combined output of the build. If
+// cmd.DiagnosticsFormat is "json", the output is only returned and not
+// printed.
+func (cmd *RunCmd) build(buildArgs []string, env []string) (output []byte, err error) {
+	buf := &bytes.Buffer{}
+	stdout, stderr := cmd.Stdout, cmd.Stderr
+	if cmd.DiagnosticsFormat == "json" {
+		stdout, stderr = io.Discard, io.Discard
+	}
+	buildCmd := exec.CommandContext(cmd.ctx, "go", buildArgs...)
+	buildCmd.Env = env
+	buildCmd.Stdout = io.MultiWriter(stdout, buf)
+	buildCmd.Stderr = io.MultiWriter(stderr, buf)
+	err = buildCmd.Run()
+	return buf.Bytes(), err
+}
+
+// startProgram runs the program in the background (piping its stdout and
+// stderr to cmd.Stdout and cmd.Stderr). The returned channel is closed once
+// the program exits.
+func (cmd *RunCmd) startProgram(env []string, name string, args []string) (*exec.Cmd, chan struct{}, error) {
+	program := exec.Command(name, args...)
+	program.Env = env
+	program.Dir = cmd.Dir
+	program.Stdout = cmd.Stdout
+	program.Stderr = cmd.Stderr
+	cmd.readyLine = nil
+	if cmd.ReadyRegexp != nil {
+		// Watch the program's output for the line that says it's ready.
+		w := newRegexpWriter(cmd.ReadyRegexp)
+		program.Stdout = io.MultiWriter(cmd.Stdout, w)
+		program.Stderr = io.MultiWriter(cmd.Stderr, w)
+		cmd.readyLine = w.matched
+	}
+	cmd.programStdin = nil
+	if cmd.Interactive {
+		// wgo reads stdin itself, the program gets the lines that aren't
+		// commands through a pipe.
+		stdin, err := program.StdinPipe()
+		if err != nil {
+			return nil, nil, err
+		}
+		cmd.programStdin = stdin
+	} else {
+		program.Stdin = cmd.Stdin
+	}
+	setpgid(program)
+	cmd.cgroup.apply(program)
+	// Kill anything the previous program left behind in the cgroup, e.g. a
+	// daemon it spawned before exiting on its own.
+	cmd.cgroup.kill()
+	err := program.Start()
+	if err != nil && cmd.cgroup.unsupported(err) {
+		fmt.Fprintf(cmd.Stderr, "[wgo] can't start the program in a cgroup (needs Linux 5.7+), falling back to process groups: %v\n", err)
+		cmd.cgroup.remove()
+		cmd.cgroup = nil
+		return cmd.startProgram(env, name, args)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	programDone := make(chan struct{})
+	go func() {
+		_ = program.Wait()
+		close(programDone)
+	}()
+	return program, programDone, nil
+}
+
+// readLines reads cmd.Stdin line by line in the background, sending every
+// line to the returned channel. The channel is closed once cmd.Stdin reaches
+// EOF.
+func (cmd *RunCmd) readLines() <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(cmd.Stdin)
+		for scanner.Scan() {
+			select {
+			case lines <- strings.TrimRight(scanner.Text(), "\r"):
+			case <-cmd.ctx.Done():
+				return
+			}
+		}
+	}()
+	return lines
+}
+
+// environ returns the environment for the build and the program: wgo's own
+// environment, followed by the contents of cmd.EnvFiles, followed by cmd.Env.
+// Later values take precedence over earlier ones.
+func (cmd *RunCmd) environ() ([]string, error) {
+	env := os.Environ()
+	for _, name := range cmd.EnvFiles {
+		vars, err := readEnvFile(name)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, vars...)
+	}
+	env = append(env, cmd.Env...)
+	return env, nil
+}
+
+// watchDependencies updates the watcher to watch exactly the directories
+// containing the package's dependencies, returning the new set of dependency
+// files. If the dependencies can't be listed, the old set is kept.
+func (cmd *RunCmd) watchDependencies(watcher Watcher, watched map[string]struct{}, deps map[string]struct{}, env []string) map[string]struct{} {
+	dirs, files, err := listDependencies(env, cmd.BuildFlags, append([]string{cmd.Package}, cmd.Files...)...)
+	if err != nil {
+		fmt.Fprintln(cmd.Stderr, err)
+		return deps
+	}
+	wanted := make(map[string]struct{}, len(dirs))
+	for _, dir := range dirs {
+		wanted[dir] = struct{}{}
+		if _, ok := watched[dir]; ok {
+			continue
+		}
+		if err := watcher.Add(dir); err == nil {
+			watched[dir] = struct{}{}
+		}
+	}
+	for dir := range watched {
+		if _, ok := wanted[dir]; ok {
+			continue
+		}
+		if err := watcher.Remove(dir); err == nil {
+			delete(watched, dir)
+		}
+	}
+	return files
+}
+
+// isDependency reports whether the file is one of the dependency files in
+// deps.
+func isDependency(deps map[string]struct{}, name string) bool {
+	if len(deps) == 0 {
+		return false
+	}
+	absName, err := filepath.Abs(name)
+	if err != nil {
+		return false
+	}
+	_, ok := deps[absName]
+	return ok
+}
+
+// isRestartFile reports whether the path is one of cmd.EnvFiles or matches
+// the restart-only regexps, meaning the program only needs to be restarted
+// (not rebuilt) when it changes.
+func (cmd *RunCmd) isRestartFile(path string) bool {
+	for _, r := range cmd.RestartFileRegexps {
+		if r.MatchString(filepath.Base(path)) {
+			return true
+		}
+	}
+	for _, r := range cmd.RestartFilepathRegexps {
+		if r.MatchString(relPath(cmd.Root, path)) {
+			return true
+		}
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	for _, name := range cmd.EnvFiles {
+		name, err := filepath.Abs(name)
+		if err == nil && name == absPath {
+			return true
+		}
+	}
+	return false
+}
+
+// stopProgram sends cmd.Signal to the program and waits for it to exit,
+// killing it (and any child processes) if it is still running after
+// cmd.StopTimeout. It returns once the program has exited.
+func (cmd *RunCmd) stopProgram(program *exec.Cmd, programDone <-chan struct{}) {
+	var processes []process
+	select {
+	case <-programDone:
+		// The program already exited on its own (e.g. during a build) and
+		// was reaped, its pid may belong to an unrelated process by now.
+	default:
+		// Find the program's descendants while it's still alive, once it
+		// exits they can no longer be traced back to it.
+		processes = descendants(program.Process.Pid)
+		err := interrupt(program, cmd.Signal)
+		signalDescendants(program, processes, cmd.Signal)
+		// If the signal couldn't be delivered there is nothing to wait for,
+		// kill the program right away.
+		if err == nil {
+			timer := time.NewTimer(cmd.StopTimeout)
+			select {
+			case <-programDone:
+			case <-timer.C:
 			}
+			timer.Stop()
 		}
 	}
+	// Even if the program exited gracefully, kill any child processes that it
+	// may have left behind.
+	cleanup(program)
+	if cmd.cgroup != nil {
+		// The cgroup holds every descendant, even the ones whose parent died.
+		cmd.cgroup.kill()
+	} else {
+		signalDescendants(program, processes, syscall.SIGKILL)
+	}
+	<-programDone
 }
 
+// setExitCode records the exit code of a program that has exited and emits a
+// ProgramExited event. Programs that were killed by a signal (i.e. by wgo
+// itself) don't report an exit code and are not recorded.
+func (cmd *RunCmd) setExitCode(program *exec.Cmd) {
+	if program.ProcessState == nil {
+		return
+	}
+	exitCode := program.ProcessState.ExitCode()
+	if exitCode >= 0 {
+		cmd.exitCode = exitCode
+	}
+	cmd.emit(Event{Type: ProgramExited, Pid: program.ProcessState.Pid(), ExitCode: exitCode})
+}
+
+// maxRestartDelay caps the delay between consecutive restarts of a program
+// that keeps exiting.
+const maxRestartDelay = time.Minute
+
+// restartResetAfter is how long a program has to run before exiting for wgo
+// to consider it healthy again, resetting the count (and so the delay) of
+// consecutive restarts.
+const restartResetAfter = time.Minute
+
+// isRestartPolicy reports whether policy is a valid RunCmd.Restart value.
+func isRestartPolicy(policy string) bool {
+	switch policy {
+	case "never", "on-failure", "always", "exit":
+		return true
+	}
+	return false
+}
+
+// restartDelay returns how long to wait before a program that was already
+// restarted n times in a row is restarted again: delay, doubled n times, up
+// to maxRestartDelay.
+func restartDelay(delay time.Duration, n int) time.Duration {
+	for i := 0; i < n && delay < maxRestartDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRestartDelay {
+		delay = maxRestartDelay
+	}
+	return delay
+}
+
+// Stop stops the watcher and the program (if running) and removes the built
+// program. It does not wait for everything to finish cleaning up, call Wait
+// for that.
 func (cmd *RunCmd) Stop() {
-	if atomic.LoadInt32(&cmd.started) == 0 {
+	cmd.mu.Lock()
+	defer cmd.mu.Unlock()
+	if cmd.cancel == nil {
 		// If Start() hasn't been called, do nothing.
 		return
 	}
-	if cmd.watcher != nil {
-		_ = cmd.watcher.Close()
-	}
-	if cmd.programPath != "" {
-		_ = os.Remove(cmd.programPath)
+	cmd.cancel()
+}
+
+// Wait waits for the RunCmd to stop and returns the exit code of the last
+// program that exited. If the last build failed, the exit code is 1.
+func (cmd *RunCmd) Wait() (exitCode int) {
+	if atomic.LoadInt32(&cmd.started) == 0 {
+		return 0
 	}
+	<-cmd.done
+	return cmd.exitCode
 }
 
+// Run starts the RunCmd and blocks until Stop() is called or (if Restart is
+// "exit") the program exits. It returns the exit code of the
+// last program that exited.
 func (cmd *RunCmd) Run() (exitCode int) {
-	return 0
+	return cmd.RunContext(context.Background())
+}
+
+// RunContext is like Run but also returns once the context is done, after
+// stopping the program and removing the built program.
+func (cmd *RunCmd) RunContext(ctx context.Context) (exitCode int) {
+	err := cmd.StartContext(ctx)
+	if err != nil {
+		if cmd.Stderr == nil {
+			cmd.Stderr = os.Stderr
+		}
+		fmt.Fprintln(cmd.Stderr, err)
+		return 1
+	}
+	return cmd.Wait()
+}
+
+// runCmdWrapper is embedded by the commands that are a RunCmd underneath
+// (WatchCmd, BuildCmd and TestCmd) and implements their Start, Stop, Wait and
+// Run methods on top of that RunCmd.
+type runCmdWrapper struct {
+	started int32
+	runCmd  *RunCmd
+}
+
+// start starts the RunCmd. Like RunCmd.StartContext, it only works once.
+func (w *runCmdWrapper) start(ctx context.Context, runCmd *RunCmd) error {
+	if !atomic.CompareAndSwapInt32(&w.started, 0, 1) {
+		return fmt.Errorf("wgo: already started")
+	}
+	w.runCmd = runCmd
+	return w.runCmd.StartContext(ctx)
+}
+
+// stop stops the RunCmd if it was started.
+func (w *runCmdWrapper) stop() {
+	if atomic.LoadInt32(&w.started) == 0 || w.runCmd == nil {
+		return
+	}
+	w.runCmd.Stop()
+}
+
+// wait waits for the RunCmd to stop and returns its exit code, or 0 if it was
+// never started.
+func (w *runCmdWrapper) wait() (exitCode int) {
+	if atomic.LoadInt32(&w.started) == 0 || w.runCmd == nil {
+		return 0
+	}
+	return w.runCmd.Wait()
+}
+
+// runContext starts the command with startContext and waits for it to stop.
+// If it can't be started, the error is printed to stderr (os.Stderr if nil)
+// and the exit code is 1.
+func (w *runCmdWrapper) runContext(ctx context.Context, startContext func(context.Context) error, stderr io.Writer) (exitCode int) {
+	err := startContext(ctx)
+	if err != nil {
+		if stderr == nil {
+			stderr = os.Stderr
+		}
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return w.wait()
+}
+
+// addBuildFlags registers every go build flag on the flagset. Parsed flags are
+// appended to flags.
+func addBuildFlags(flagset *flag.FlagSet, flags *[]string) {
+	for _, name := range buildFlags {
+		switch name {
+		case "a", "n", "race", "msan", "asan", "v", "work", "x",
+			"buildvcs", "linkshared", "modcacherw", "trimpath": // bool flags
+			flagset.Var(passthroughFlag{name: name, isBool: true, flags: flags}, name, "The -"+name+" flag in go build.")
+		default:
+			flagset.Var(passthroughFlag{name: name, flags: flags}, name, "The -"+name+" flag in go build.")
+		}
+	}
+}
+
+// passthroughFlag is a flag.Value that doesn't hold a value of its own but
+// appends itself to a list of flags meant for another command (like go build).
+type passthroughFlag struct {
+	name   string
+	isBool bool
+	flags  *[]string
+}
+
+func (f passthroughFlag) String() string { return "" }
+
+func (f passthroughFlag) IsBoolFlag() bool { return f.isBool }
+
+func (f passthroughFlag) Set(value string) error {
+	*f.flags = append(*f.flags, "-"+f.name+"="+value)
+	return nil
 }
 
 func compileRegexps(patterns []string) ([]*regexp.Regexp, error) {
@@ -338,6 +1221,22 @@ func compileRegexps(patterns []string) ([]*regexp.Regexp, error) {
 	return regexps, nil
 }
 
+func parseSignal(name string) (os.Signal, error) {
+	switch strings.TrimPrefix(strings.ToUpper(name), "SIG") {
+	case "INT":
+		return syscall.SIGINT, nil
+	case "TERM":
+		return syscall.SIGTERM, nil
+	case "HUP":
+		return syscall.SIGHUP, nil
+	case "QUIT":
+		return syscall.SIGQUIT, nil
+	case "KILL":
+		return syscall.SIGKILL, nil
+	}
+	return nil, fmt.Errorf("unsupported signal %q", name)
+}
+
 func isDir(path string) bool {
 	fileinfo, err := os.Stat(path)
 	if err != nil {
@@ -346,37 +1245,40 @@ func isDir(path string) bool {
 	return fileinfo.IsDir()
 }
 
-func isValid(fileRegexps, filepathRegexps, excludeFileRegexps, excludeFilepathRegexps []*regexp.Regexp, path string) bool {
-	basename := filepath.Base(path)
-	normalizedPath := filepath.ToSlash(path)
-	for _, r := range excludeFileRegexps {
-		if r.MatchString(basename) {
-			return false
+// relPath returns the path relative to root, using forward slashes as the
+// separator. If the path cannot be made relative to root it is returned as-is
+// (but still with forward slashes).
+func relPath(root, path string) string {
+	// filepath.Rel can't relate absolute paths to relative ones, make both
+	// absolute first.
+	if filepath.IsAbs(root) != filepath.IsAbs(path) {
+		absRoot, err1 := filepath.Abs(root)
+		absPath, err2 := filepath.Abs(path)
+		if err1 == nil && err2 == nil {
+			root, path = absRoot, absPath
 		}
 	}
-	for _, r := range excludeFilepathRegexps {
-		if r.MatchString(normalizedPath) {
-			return false
-		}
-	}
-	for _, r := range fileRegexps {
-		if r.MatchString(basename) {
-			return true
-		}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return filepath.ToSlash(path)
 	}
-	for _, r := range filepathRegexps {
-		if r.MatchString(normalizedPath) {
-			return true
+	return filepath.ToSlash(rel)
+}
+
+// isValid reports whether a file is watched according to the rules. The last
+// rule that matches the file wins.
+func isValid(rules []Rule, path string) bool {
+	valid := false
+	for _, rule := range rules {
+		if rule.Matcher.Match(path) {
+			valid = !rule.Exclude
 		}
 	}
-	if strings.HasSuffix(path, ".go") {
-		return true
-	}
-	return false
+	return valid
 }
 
 // TODO: check if newly added directories are watched (as well as their subdirectories).
-func addDirsRecursively(watcher *fsnotify.Watcher, watched map[string]struct{}, dirRegexps, excludeDirRegexps []*regexp.Regexp, dir string) {
+func addDirsRecursively(watcher Watcher, watched map[string]struct{}, ig *ignorer, dirRegexps, excludeDirRegexps []*regexp.Regexp, root, dir string) {
 	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return nil
@@ -391,7 +1293,12 @@ func addDirsRecursively(watcher *fsnotify.Watcher, watched map[string]struct{},
 		if basename == ".git" || basename == ".hg" || basename == ".idea" || basename == ".vscode" || basename == ".settings" {
 			return filepath.SkipDir
 		}
-		normalizedPath := filepath.ToSlash(path)
+		// The root is always watched, even if it happens to be ignored by a
+		// parent directory's .gitignore.
+		if path != root && ig.isIgnored(path, true) {
+			return filepath.SkipDir
+		}
+		normalizedPath := relPath(root, path)
 		for _, r := range excludeDirRegexps {
 			if r.MatchString(normalizedPath) {
 				return filepath.SkipDir
@@ -418,7 +1325,7 @@ func addDirsRecursively(watcher *fsnotify.Watcher, watched map[string]struct{},
 }
 
 // TODO: check if newly removed directories are removed (as well as their subdirectories).
-func removeDirsRecursively(watcher *fsnotify.Watcher, watched map[string]struct{}, dir string) {
+func removeDirsRecursively(watcher Watcher, watched map[string]struct{}, dir string) {
 	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return nil
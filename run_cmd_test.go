@@ -0,0 +1,106 @@
+package wgo
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRunCommand(t *testing.T) {
+	tests := []struct {
+		args        []string
+		pkg         string
+		files       []string
+		buildFlags  []string
+		programArgs []string
+		err         string // If non-empty, RunCommand must fail with an error containing it.
+	}{{
+		args: []string{"."},
+		pkg:  ".",
+	}, {
+		args:        []string{".", "foo", "bar"},
+		pkg:         ".",
+		programArgs: []string{"foo", "bar"},
+	}, {
+		// Flags after the package belong to the program.
+		args:        []string{".", "-port", "80"},
+		pkg:         ".",
+		programArgs: []string{"-port", "80"},
+	}, {
+		args:        []string{"-tags=fts5", "./cmd/server", "--", "-port", "80"},
+		pkg:         "./cmd/server",
+		buildFlags:  []string{"-tags=fts5"},
+		programArgs: []string{"-port", "80"},
+	}, {
+		args:        []string{"main.go", "helpers.go", "foo"},
+		pkg:         "main.go",
+		files:       []string{"helpers.go"},
+		programArgs: []string{"foo"},
+	}, {
+		args:        []string{"main.go", "helpers.go", "--", "extra.go"},
+		pkg:         "main.go",
+		files:       []string{"helpers.go"},
+		programArgs: []string{"extra.go"},
+	}, {
+		args: []string{".", "--"},
+		pkg:  ".",
+	}, {
+		args: []string{"-port", "80", "."},
+		err:  "unknown flag -port (if it is meant for the program, pass it after --",
+	}, {
+		args: []string{".", "foo", "--", "bar"},
+		err:  `unexpected argument "foo" after the package`,
+	}, {
+		args: []string{".", "-race", "--", "bar"},
+		err:  "flag -race after the package",
+	}, {
+		// A -- before the package only ends the flags.
+		args:        []string{"--", "main.go", "-v"},
+		pkg:         "main.go",
+		programArgs: []string{"-v"},
+	}, {
+		args: []string{"--"},
+		err:  "package or file not provided",
+	}, {
+		args: nil,
+		err:  "package or file not provided",
+	}}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			args := append([]string(nil), tt.args...)
+			cmd, err := RunCommand(args...)
+			if fmt.Sprintf("%q", args) != fmt.Sprintf("%q", tt.args) {
+				t.Errorf("RunCommand modified its args: got %q, want %q", args, tt.args)
+			}
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want an error containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cmd.Package != tt.pkg {
+				t.Errorf("Package: got %q, want %q", cmd.Package, tt.pkg)
+			}
+			// %q prints nil and empty slices the same way.
+			if fmt.Sprintf("%q", cmd.Files) != fmt.Sprintf("%q", tt.files) {
+				t.Errorf("Files: got %q, want %q", cmd.Files, tt.files)
+			}
+			if fmt.Sprintf("%q", cmd.BuildFlags) != fmt.Sprintf("%q", tt.buildFlags) {
+				t.Errorf("BuildFlags: got %q, want %q", cmd.BuildFlags, tt.buildFlags)
+			}
+			if fmt.Sprintf("%q", cmd.Args) != fmt.Sprintf("%q", tt.programArgs) {
+				t.Errorf("Args: got %q, want %q", cmd.Args, tt.programArgs)
+			}
+			// The program's args must not share memory with the caller's.
+			if len(cmd.Args) > 0 {
+				cmd.Args[0] = "changed"
+				if fmt.Sprintf("%q", args) != fmt.Sprintf("%q", tt.args) {
+					t.Errorf("Args shares its backing array with the caller's args")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package wgo
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Copied from `go help testflag`.
+var testFlags = [...]string{
+	"bench", "benchmem", "benchtime", "blockprofile", "blockprofilerate",
+	"count", "cover", "covermode", "coverpkg", "coverprofile", "cpu",
+	"cpuprofile", "failfast", "fullpath", "json", "list", "memprofile",
+	"memprofilerate", "mutexprofile", "mutexprofilefraction", "outputdir",
+	"parallel", "run", "short", "shuffle", "skip", "timeout", "trace", "vet",
+}
+
+// TestCmd runs go test, rerunning it whenever *.go files change. After the
+// first run, only the packages affected by the changed files (the packages
+// containing them and the packages whose tests depend on them) are retested.
+type TestCmd struct {
+	// (Required)
+	Packages  []string  // The packages (or patterns like ./...) to test.
+	Env       []string  // Added on top of os.Environ() and EnvFiles.
+	EnvFiles  []string  // Dotenv files, re-read on every rerun.
+	Stdin     io.Reader // Passed to go test, see Interactive.
+	Stdout    io.Writer
+	Stderr    io.Writer
+	TestFlags []string // Build and test flags passed to go test.
+	// WatchOptions decide which files are watched and how every cycle is
+	// reported.
+	WatchOptions
+	// ProcessOptions decide how go test is run, stopped and restarted.
+	ProcessOptions
+	runCmdWrapper
+}
+
+func TestCommand(args ...string) (*TestCmd, error) {
+	cmd := TestCmd{
+		TestFlags: make([]string, 0, len(buildFlags)+len(testFlags)),
+	}
+	flagset := flag.NewFlagSet("", flag.ContinueOnError)
+	cmd.WatchOptions.addFlags(flagset)
+	cmd.ProcessOptions.addFlags(flagset)
+	addEnvFlags(flagset, &cmd.Env, &cmd.EnvFiles)
+	addBuildFlags(flagset, &cmd.TestFlags)
+	for _, name := range testFlags {
+		switch name {
+		case "benchmem", "cover", "failfast", "fullpath", "json", "short": // bool flags
+			flagset.Var(passthroughFlag{name: name, isBool: true, flags: &cmd.TestFlags}, name, "The -"+name+" flag in go test.")
+		default:
+			flagset.Var(passthroughFlag{name: name, flags: &cmd.TestFlags}, name, "The -"+name+" flag in go test.")
+		}
+	}
+	flagset.Usage = func() {
+		fmt.Fprint(flagset.Output(), `Test the packages, retesting the affected packages whenever *.go files change.
+Usage:
+  wgo test [TEST_FLAGS...] [packages]
+  wgo test ./...
+  wgo test -run TestFoo -v ./internal/...
+Flags:
+  Any flag that works with 'go test' works here.
+`+watchFlagsUsage+`
+`+processFlagsUsage("command")+`
+  -env
+        A KEY=VALUE environment variable passed to go test. Can be repeated.
+  -envfile
+        A dotenv file containing KEY=VALUE lines passed to go test. Can be
+        repeated. The file is re-read (and the tests rerun) whenever it
+        changes.
+`)
+	}
+	err := flagset.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Packages = flagset.Args()
+	if len(cmd.Packages) == 0 {
+		cmd.Packages = []string{"."}
+	}
+	return &cmd, nil
+}
+
+// Start starts watching files and runs the tests in the background. Call Wait
+// to wait for it to finish, or Stop to end it.
+func (cmd *TestCmd) Start() error {
+	return cmd.StartContext(context.Background())
+}
+
+// StartContext is like Start but also stops everything (as if Stop() was
+// called) once the context is done.
+func (cmd *TestCmd) StartContext(ctx context.Context) error {
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+	// TestCmd is a RunCmd that runs go test on the affected packages instead
+	// of building and running a Go package.
+	return cmd.start(ctx, &RunCmd{
+		Env:            cmd.Env,
+		EnvFiles:       cmd.EnvFiles,
+		Stdin:          cmd.Stdin,
+		Stdout:         cmd.Stdout,
+		Stderr:         cmd.Stderr,
+		WatchOptions:   cmd.WatchOptions,
+		ProcessOptions: cmd.ProcessOptions,
+		command:        cmd.command,
+	})
+}
+
+// command returns the go test command that tests the packages affected by
+// the changed files. If changed is empty, every package is tested.
+func (cmd *TestCmd) command(env, changed []string) []string {
+	packages := cmd.Packages
+	if len(changed) > 0 {
+		affected, err := affectedPackages(env, onlyBuildFlags(cmd.TestFlags), cmd.Packages, changed)
+		if err != nil {
+			// Fall back to testing everything.
+			fmt.Fprintln(cmd.Stderr, err)
+		} else if len(affected) == 0 {
+			fmt.Fprintln(cmd.Stderr, "[wgo] no tests affected by the change")
+			return nil
+		} else {
+			packages = affected
+		}
+	}
+	args := make([]string, 0, len(cmd.TestFlags)+len(packages)+2)
+	args = append(args, "go", "test")
+	args = append(args, cmd.TestFlags...)
+	args = append(args, packages...)
+	return args
+}
+
+// Stop stops the watcher and the tests (if running). It does not wait for
+// everything to finish cleaning up, call Wait for that.
+func (cmd *TestCmd) Stop() {
+	cmd.stop()
+}
+
+// Wait waits for the TestCmd to stop and returns the exit code of the last go
+// test run.
+func (cmd *TestCmd) Wait() (exitCode int) {
+	return cmd.wait()
+}
+
+// Run starts the TestCmd and blocks until Stop() is called or (if Restart is
+// "exit") go test exits. It returns the exit code of the last go test run.
+func (cmd *TestCmd) Run() (exitCode int) {
+	return cmd.RunContext(context.Background())
+}
+
+// RunContext is like Run but also returns once the context is done.
+func (cmd *TestCmd) RunContext(ctx context.Context) (exitCode int) {
+	return cmd.runContext(ctx, cmd.StartContext, cmd.Stderr)
+}
+
+// affectedPackages returns the import paths of the packages matching the
+// patterns whose tests are affected by the changed files, using `go list
+// -deps -test -json`. A package is affected if one of the changed files lives
+// in it or in one of the packages its test binary depends on. The build flags
+// (like -tags) are passed to go list so that it sees the same files as go
+// test.
+func affectedPackages(env, buildFlags, patterns, changed []string) ([]string, error) {
+	args := make([]string, 0, len(buildFlags)+len(patterns)+2)
+	args = append(args, "-deps", "-test")
+	args = append(args, buildFlags...)
+	args = append(args, patterns...)
+	packages, err := goList(env, args...)
+	if err != nil {
+		return nil, err
+	}
+	changedDirs := make(map[string]struct{})
+	for _, name := range changed {
+		dir, err := filepath.Abs(filepath.Dir(name))
+		if err != nil {
+			continue
+		}
+		changedDirs[dir] = struct{}{}
+	}
+	// Figure out which packages the changed files belong to. Test variants
+	// like "example.com/foo [example.com/foo.test]" are reduced to their
+	// plain import path.
+	changedPackages := make(map[string]struct{})
+	for _, pkg := range packages {
+		if pkg.Standard {
+			continue
+		}
+		if _, ok := changedDirs[pkg.Dir]; ok {
+			changedPackages[plainImportPath(pkg.ImportPath)] = struct{}{}
+		}
+	}
+	if len(changedPackages) == 0 {
+		// The changed files don't belong to any package we know of (maybe a
+		// package was just added), test everything to be safe.
+		return patterns, nil
+	}
+	// Every package with tests has a generated "<pkg>.test" main package
+	// whose dependencies include everything its tests depend on.
+	var affected []string
+	for _, pkg := range packages {
+		if pkg.Name != "main" || !strings.HasSuffix(pkg.ImportPath, ".test") {
+			continue
+		}
+		importPath := strings.TrimSuffix(pkg.ImportPath, ".test")
+		isAffected := false
+		if _, ok := changedPackages[importPath]; ok {
+			isAffected = true
+		}
+		for _, dep := range pkg.Deps {
+			if isAffected {
+				break
+			}
+			if _, ok := changedPackages[plainImportPath(dep)]; ok {
+				isAffected = true
+			}
+		}
+		if isAffected {
+			affected = append(affected, importPath)
+		}
+	}
+	sort.Strings(affected)
+	return affected, nil
+}
+
+// onlyBuildFlags returns the go build flags among the -name=value flags,
+// leaving out the test-only flags that go list doesn't understand.
+func onlyBuildFlags(flags []string) []string {
+	var result []string
+	for _, flag := range flags {
+		name := strings.TrimPrefix(flag, "-")
+		if i := strings.Index(name, "="); i >= 0 {
+			name = name[:i]
+		}
+		for _, buildFlag := range buildFlags {
+			if name == buildFlag {
+				result = append(result, flag)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// plainImportPath strips the " [pkg.test]" suffix from the import paths of
+// test variants.
+func plainImportPath(importPath string) string {
+	if i := strings.Index(importPath, " ["); i >= 0 {
+		return importPath[:i]
+	}
+	return importPath
+}
@@ -3,31 +3,69 @@
 package wgo
 
 import (
+	"os"
 	"os/exec"
 	"syscall"
 )
 
+func interrupt(program *exec.Cmd, signal os.Signal) error {
+	if program.Process == nil {
+		return nil
+	}
+	sig, ok := signal.(syscall.Signal)
+	if !ok {
+		return program.Process.Signal(signal)
+	}
+	// Signal the whole process group so that child processes (e.g. those
+	// spawned by a shell) also get a chance to clean up. setpgid made the
+	// program the leader of its own group, so the pgid is the pid.
+	return syscall.Kill(-program.Process.Pid, sig)
+}
+
 func cleanup(program *exec.Cmd) {
 	if program.Process == nil {
 		return
 	}
 	// https://stackoverflow.com/questions/22470193/why-wont-go-kill-a-child-process-correctly
-	_ = cmd.Process.Kill()
-	pgid, err := syscall.Getpgid(cmd.Process.Pid)
-	if err == nil {
-		_ = syscall.Kill(-pgid, syscall.SIGKILL)
-	} else {
-		_ = syscall.Kill(-program.Process.Pid, syscall.SIGKILL)
-	}
-	// Wait releases any resources associated with the Process.
-	_, _ = program.Process.Wait()
-	_ = os.Remove(program.Path)
+	//
+	// Don't look the pgid up with Getpgid, the program may already have been
+	// reaped and its pid reused. The pgid is the pid (see setpgid), and the
+	// pid can't be reused while the group still has members.
+	_ = syscall.Kill(-program.Process.Pid, syscall.SIGKILL)
+	_ = program.Process.Kill()
 }
 
 func setpgid(program *exec.Cmd) {
 	// https://stackoverflow.com/questions/22470193/why-wont-go-kill-a-child-process-correctly
+	//
+	// Setsid puts the program in a new process group (pgid == pid) like
+	// Setpgid would, but also in a new session without a controlling
+	// terminal. A background process group on wgo's terminal would be
+	// stopped with SIGTTIN as soon as it read from the inherited stdin.
 	program.SysProcAttr = &syscall.SysProcAttr{
-		Setpgid: true,
 		Setsid: true,
 	}
 }
+
+// signalDescendants sends the signal to each of the program's descendants
+// that is still running and left its process group, the rest already got it
+// from interrupt or cleanup. Sending it twice would look like a second
+// Ctrl-C, which many programs take as a request to quit immediately.
+func signalDescendants(program *exec.Cmd, processes []process, signal os.Signal) {
+	sig, ok := signal.(syscall.Signal)
+	if !ok {
+		return
+	}
+	for _, p := range processes {
+		// The pid may have been reused by an unrelated process since the
+		// descendants were listed.
+		if !p.running() {
+			continue
+		}
+		pgid, err := syscall.Getpgid(p.pid)
+		if err != nil || pgid == program.Process.Pid {
+			continue
+		}
+		_ = syscall.Kill(p.pid, sig)
+	}
+}
@@ -6,17 +6,39 @@ import (
 	"os"
 	"os/exec"
 	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/windows"
 )
 
+func interrupt(program *exec.Cmd, signal os.Signal) error {
+	if program.Process == nil {
+		return nil
+	}
+	// Windows can't deliver signals to other processes. The closest thing is
+	// a Ctrl-Break, which console programs (including Go programs, as
+	// os.Interrupt) can handle. It reaches the program's whole process group,
+	// see setpgid. It fails if wgo isn't attached to a console.
+	return windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, uint32(program.Process.Pid))
+}
+
 func cleanup(program *exec.Cmd) {
 	if program.Process == nil {
 		return
 	}
 	// https://stackoverflow.com/a/44551450
 	exec.Command("TASKKILL", "/T", "/F", "/PID", strconv.Itoa(program.Process.Pid)).Run()
-	_ = os.Remove(program.Path)
 }
 
 func setpgid(program *exec.Cmd) {
-	// Does nothing on windows.
+	// Start the program in its own process group so that interrupt can send
+	// it a Ctrl-Break without it reaching wgo too.
+	program.SysProcAttr = &syscall.SysProcAttr{
+		CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP,
+	}
+}
+
+func signalDescendants(program *exec.Cmd, processes []process, signal os.Signal) {
+	// Does nothing on windows, TASKKILL /T already covers the whole process
+	// tree.
 }
@@ -0,0 +1,123 @@
+package wgo
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+)
+
+// WatchCmd runs an arbitrary command, rerunning it whenever files change. It
+// is the general purpose counterpart of RunCmd: instead of building and
+// running a Go package it runs whatever command it is given.
+type WatchCmd struct {
+	// (Required)
+	Name     string   // The command to run.
+	Args     []string // The arguments to the command.
+	Env      []string // Added on top of os.Environ() and EnvFiles.
+	EnvFiles []string // Dotenv files, re-read on every rerun.
+	Dir      string   // The working directory of the command. Defaults to wgo's working directory.
+	Stdin    io.Reader
+	Stdout   io.Writer
+	Stderr   io.Writer
+	// WatchOptions decide which files are watched and how every cycle is
+	// reported.
+	WatchOptions
+	// ProcessOptions decide how the command is run, stopped and restarted.
+	ProcessOptions
+	runCmdWrapper
+}
+
+func WatchCommand(args ...string) (*WatchCmd, error) {
+	var cmd WatchCmd
+	flagset := flag.NewFlagSet("", flag.ContinueOnError)
+	cmd.WatchOptions.addFlags(flagset)
+	cmd.ProcessOptions.addFlags(flagset)
+	flagset.StringVar(&cmd.Dir, "workdir", "", "")
+	addEnvFlags(flagset, &cmd.Env, &cmd.EnvFiles)
+	flagset.Usage = func() {
+		fmt.Fprint(flagset.Output(), `Run a command, rerunning it whenever *.go files (or the files matching the file patterns) change.
+Usage:
+  wgo watch [FILE_PATTERNS...] -- <command> [ARGS...]
+  wgo watch -files .css -- tailwind build
+  wgo watch -- sh -c 'go test ./...'
+Flags:
+`+watchFlagsUsage+`
+`+processFlagsUsage("command")+`
+  -workdir
+        The directory to run the command in. Defaults to the current
+        directory.
+  -env
+        A KEY=VALUE environment variable passed to the command. Can be
+        repeated. The command also inherits wgo's own environment.
+  -envfile
+        A dotenv file containing KEY=VALUE lines passed to the command. Can be
+        repeated. The file is re-read (and the command rerun) whenever it
+        changes.
+`)
+	}
+	err := flagset.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	flagArgs := flagset.Args()
+	if len(flagArgs) == 0 {
+		return nil, fmt.Errorf("command not provided")
+	}
+	cmd.Name, cmd.Args = flagArgs[0], flagArgs[1:]
+	return &cmd, nil
+}
+
+// Start starts watching files and runs the command in the background. Call
+// Wait to wait for it to finish, or Stop to end it.
+func (cmd *WatchCmd) Start() error {
+	return cmd.StartContext(context.Background())
+}
+
+// StartContext is like Start but also stops everything (as if Stop() was
+// called) once the context is done.
+func (cmd *WatchCmd) StartContext(ctx context.Context) error {
+	if cmd.Name == "" {
+		return fmt.Errorf("wgo: command not provided")
+	}
+	// WatchCmd is a RunCmd that runs a command instead of building and
+	// running a Go package.
+	return cmd.start(ctx, &RunCmd{
+		Env:            cmd.Env,
+		EnvFiles:       cmd.EnvFiles,
+		Dir:            cmd.Dir,
+		Stdin:          cmd.Stdin,
+		Stdout:         cmd.Stdout,
+		Stderr:         cmd.Stderr,
+		WatchOptions:   cmd.WatchOptions,
+		ProcessOptions: cmd.ProcessOptions,
+		command: func(env, changed []string) []string {
+			return append([]string{cmd.Name}, cmd.Args...)
+		},
+	})
+}
+
+// Stop stops the watcher and the command (if running). It does not wait for
+// everything to finish cleaning up, call Wait for that.
+func (cmd *WatchCmd) Stop() {
+	cmd.stop()
+}
+
+// Wait waits for the WatchCmd to stop and returns the exit code of the last
+// command that exited.
+func (cmd *WatchCmd) Wait() (exitCode int) {
+	return cmd.wait()
+}
+
+// Run starts the WatchCmd and blocks until Stop() is called or (if Restart is
+// "exit") the command exits. It returns the exit code of the
+// last command that exited.
+func (cmd *WatchCmd) Run() (exitCode int) {
+	return cmd.RunContext(context.Background())
+}
+
+// RunContext is like Run but also returns once the context is done, after
+// stopping the command.
+func (cmd *WatchCmd) RunContext(ctx context.Context) (exitCode int) {
+	return cmd.runContext(ctx, cmd.StartContext, cmd.Stderr)
+}
@@ -0,0 +1,238 @@
+package wgo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watcher watches directories for file events. Like fsnotify, adding a
+// directory only watches the files directly inside it (not recursively).
+type Watcher interface {
+	Add(name string) error
+	Remove(name string) error
+	Close() error
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+}
+
+// NewWatcher returns a Watcher backed by fsnotify, which relies on the
+// operating system to report file events.
+func NewWatcher() (Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &fsnotifyWatcher{watcher: watcher}, nil
+}
+
+type fsnotifyWatcher struct {
+	watcher *fsnotify.Watcher
+}
+
+func (w *fsnotifyWatcher) Add(name string) error         { return w.watcher.Add(name) }
+func (w *fsnotifyWatcher) Remove(name string) error      { return w.watcher.Remove(name) }
+func (w *fsnotifyWatcher) Close() error                  { return w.watcher.Close() }
+func (w *fsnotifyWatcher) Events() <-chan fsnotify.Event { return w.watcher.Events }
+func (w *fsnotifyWatcher) Errors() <-chan error          { return w.watcher.Errors }
+
+// NewPollingWatcher returns a Watcher that detects file events by calling
+// os.Stat on every watched file once per interval. It is slower than the
+// fsnotify Watcher but works where fsnotify doesn't, such as Docker bind
+// mounts, NFS or VirtualBox shared folders.
+func NewPollingWatcher(interval time.Duration) Watcher {
+	if interval <= 0 {
+		interval = 500 * time.Millisecond
+	}
+	w := &pollingWatcher{
+		interval:  interval,
+		events:    make(chan fsnotify.Event),
+		errors:    make(chan error),
+		snapshots: make(map[string]map[string]os.FileInfo),
+		done:      make(chan struct{}),
+	}
+	go w.poll()
+	return w
+}
+
+type pollingWatcher struct {
+	interval time.Duration
+	events   chan fsnotify.Event
+	errors   chan error
+	mu       sync.Mutex
+	// snapshots maps each watched name to the file info of every file inside
+	// it as of the last poll (keyed by path). If the name is a file instead of
+	// a directory, the snapshot only contains the file itself.
+	snapshots map[string]map[string]os.FileInfo
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func (w *pollingWatcher) Add(name string) error {
+	snapshot, err := takeSnapshot(name)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	select {
+	case <-w.done:
+		return errors.New("polling watcher already closed")
+	default:
+	}
+	if _, ok := w.snapshots[name]; !ok {
+		w.snapshots[name] = snapshot
+	}
+	return nil
+}
+
+func (w *pollingWatcher) Remove(name string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.snapshots[name]; !ok {
+		return errors.New("can't remove non-existent polling watch for: " + name)
+	}
+	delete(w.snapshots, name)
+	return nil
+}
+
+func (w *pollingWatcher) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.done)
+	})
+	return nil
+}
+
+func (w *pollingWatcher) Events() <-chan fsnotify.Event { return w.events }
+
+func (w *pollingWatcher) Errors() <-chan error { return w.errors }
+
+// poll compares every watched name against its snapshot once per interval,
+// sending an event for every difference found.
+func (w *pollingWatcher) poll() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+		w.mu.Lock()
+		names := make([]string, 0, len(w.snapshots))
+		for name := range w.snapshots {
+			names = append(names, name)
+		}
+		w.mu.Unlock()
+		for _, name := range names {
+			w.mu.Lock()
+			oldSnapshot, ok := w.snapshots[name]
+			w.mu.Unlock()
+			if !ok {
+				continue // Removed in the meantime.
+			}
+			newSnapshot, err := takeSnapshot(name)
+			if err != nil {
+				if !errors.Is(err, os.ErrNotExist) {
+					if !w.sendError(err) {
+						return
+					}
+					continue
+				}
+				// The watched name itself is gone, stop watching it.
+				w.mu.Lock()
+				delete(w.snapshots, name)
+				w.mu.Unlock()
+				if !w.sendEvent(fsnotify.Event{Name: name, Op: fsnotify.Remove}) {
+					return
+				}
+				continue
+			}
+			w.mu.Lock()
+			if _, ok := w.snapshots[name]; ok {
+				w.snapshots[name] = newSnapshot
+			}
+			w.mu.Unlock()
+			for path, oldInfo := range oldSnapshot {
+				if _, ok := newSnapshot[path]; !ok {
+					if !w.sendEvent(fsnotify.Event{Name: path, Op: fsnotify.Remove}) {
+						return
+					}
+					continue
+				}
+				newInfo := newSnapshot[path]
+				var op fsnotify.Op
+				switch {
+				case !oldInfo.ModTime().Equal(newInfo.ModTime()) || oldInfo.Size() != newInfo.Size():
+					op = fsnotify.Write
+				case oldInfo.Mode() != newInfo.Mode():
+					op = fsnotify.Chmod
+				default:
+					continue
+				}
+				if !w.sendEvent(fsnotify.Event{Name: path, Op: op}) {
+					return
+				}
+			}
+			for path := range newSnapshot {
+				if _, ok := oldSnapshot[path]; !ok {
+					if !w.sendEvent(fsnotify.Event{Name: path, Op: fsnotify.Create}) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// sendEvent sends the event, returning false if the watcher was closed
+// instead.
+func (w *pollingWatcher) sendEvent(event fsnotify.Event) bool {
+	select {
+	case w.events <- event:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// sendError sends the error, returning false if the watcher was closed
+// instead.
+func (w *pollingWatcher) sendError(err error) bool {
+	select {
+	case w.errors <- err:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// takeSnapshot returns the file info of every file directly inside the
+// directory, keyed by path. If name is a file, the snapshot only contains
+// the file itself.
+func takeSnapshot(name string) (map[string]os.FileInfo, error) {
+	fileinfo, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if !fileinfo.IsDir() {
+		return map[string]os.FileInfo{name: fileinfo}, nil
+	}
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := make(map[string]os.FileInfo, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue // The file was removed after ReadDir.
+		}
+		snapshot[filepath.Join(name, entry.Name())] = info
+	}
+	return snapshot, nil
+}
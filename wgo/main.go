@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
@@ -12,14 +13,24 @@ import (
 )
 
 const helptext = `Usage:
-  wgo run [BUILD_FLAGS...] <package_or_file> [ARGS...] # Build and run the package, rebuilding and rerunning whenever *.{go,html,tmpl,tpl} files change.
+  wgo run [FLAGS...] [BUILD_FLAGS...] <package | files...> [-- ARGS...] # Build and run the package, rebuilding and rerunning whenever files change.
+  wgo watch [FILE_PATTERNS...] -- <command> [ARGS...]                   # Run a command, rerunning it whenever files change.
+  wgo build [BUILD_FLAGS...] [package]                                  # Build the package, rebuilding whenever files change.
+  wgo test [TEST_FLAGS...] [packages]                                   # Test the packages, retesting the affected packages whenever files change.
 Example:
   wgo run main.go
+  wgo run main.go helpers.go
   wgo run .
   wgo run -tags=fts5 ./cmd/main
-  wgo run -tags=fts5 ./cmd/main arg1 arg2 arg3
+  wgo run -tags=fts5 ./cmd/main -- -port 8080 arg1 arg2
+  wgo run -restart=on-failure main.go # restart the program if it crashes
+  wgo run -restart=exit main.go       # exit with the program's exit code once it exits
+  wgo watch -files .css -- tailwind build
+  wgo watch -- sh -c 'go test ./...'
+  wgo build -o ./bin/server ./cmd/server
+  wgo test -v ./...
 
-Run wgo run -h for more details about specific flags.
+Run wgo <command> -h for more details about specific flags.
 `
 
 func main() {
@@ -27,29 +38,52 @@ func main() {
 		fmt.Print(helptext)
 		os.Exit(0)
 	}
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP)
-	cmd, args := os.Args[1], os.Args[2:]
+	os.Exit(run(os.Args[1], os.Args[2:]))
+}
+
+// run runs the wgo command and returns the exit code. It is separate from
+// main so that its deferred calls run before os.Exit.
+func run(cmd string, args []string) (exitCode int) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP)
+	defer stop()
 	switch cmd {
 	case "run":
 		runCmd, err := wgo.RunCommand(args...)
 		if err != nil {
-			exit(cmd, err)
+			return usageError(cmd, err)
 		}
-		go runCmd.Start()
-		<-sigs
-		runCmd.Stop()
+		return runCmd.RunContext(ctx)
+	case "watch":
+		watchCmd, err := wgo.WatchCommand(args...)
+		if err != nil {
+			return usageError(cmd, err)
+		}
+		return watchCmd.RunContext(ctx)
+	case "build":
+		buildCmd, err := wgo.BuildCommand(args...)
+		if err != nil {
+			return usageError(cmd, err)
+		}
+		return buildCmd.RunContext(ctx)
+	case "test":
+		testCmd, err := wgo.TestCommand(args...)
+		if err != nil {
+			return usageError(cmd, err)
+		}
+		return testCmd.RunContext(ctx)
 	default:
 		fmt.Println("wgo " + cmd + ": unknown command")
 		fmt.Println("Run 'wgo' for usage.")
-		os.Exit(1)
+		return 1
 	}
 }
 
-func exit(cmd string, err error) {
+// usageError prints the error from parsing the command's flags and returns
+// the exit code: 0 if -h was asked for, 1 otherwise.
+func usageError(cmd string, err error) (exitCode int) {
 	if errors.Is(err, flag.ErrHelp) {
-		os.Exit(0)
+		return 0
 	}
 	fmt.Fprintln(os.Stderr, cmd+": "+err.Error())
-	os.Exit(1)
+	return 1
 }